@@ -1,80 +1,252 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/golang/glog"
+	"os"
+	"strings"
+	"time"
 
 	"appMetric/pkg/addon"
 	ali "appMetric/pkg/alligator"
+	"appMetric/pkg/config"
 	"appMetric/pkg/server"
-	"github.com/songbinliu/xfire/pkg/prometheus"
+)
+
+const (
+	// the timeout of the connectivity test of Prometheus at startup
+	testPrometheusTimeout = 10 * time.Second
 )
 
 var (
+	configFile     string
 	prometheusHost string
 	port           int
+	getterTimeout  time.Duration
+	scrapeInterval time.Duration
+	mergePolicy    string
+	mergeOrder     string
+	getterConfig   string
+	reloadInterval time.Duration
+
+	unreachableTimeout time.Duration
+
+	// settings of the Prometheus client, for the first endpoint
+	promCAFile             string
+	promCertFile           string
+	promKeyFile            string
+	promInsecureSkipVerify bool
+	promBearerTokenFile    string
+	promHeaders            string
+	promMaxRetries         int
+	promRetryBackoff       time.Duration
 )
 
 func parseFlags() {
 	flag.Set("logtostderr", "true")
+	flag.StringVar(&configFile, "config", "", "json config file of Prometheus endpoints, getters and server settings; flags and environment variables override it")
 	flag.StringVar(&prometheusHost, "promUrl", "http://localhost:9090", "the address of prometheus server")
 	flag.IntVar(&port, "port", 8081, "port to expose metrics")
+	flag.DurationVar(&getterTimeout, "getterTimeout", 30*time.Second, "deadline for each entity getter; a getter exceeding it is dropped from the response")
+	flag.DurationVar(&scrapeInterval, "scrapeInterval", 0, "if positive, scrape Prometheus in background on this interval, and serve the cached snapshot; otherwise, query Prometheus for each request")
+	flag.StringVar(&mergePolicy, "mergePolicy", string(ali.MergePreferFirst), "how to merge entities sharing the same UID: prefer-first, prefer-category, or keep-both")
+	flag.StringVar(&mergeOrder, "mergeCategories", "", "comma separated categories in priority order, used by the prefer-category merge policy")
+	flag.StringVar(&getterConfig, "getterConfig", "", "json file defining extra getters declaratively, see scripts/config/getters.json; YAML is not supported")
+	flag.DurationVar(&reloadInterval, "reloadInterval", 10*time.Second, "interval to check the config files for changes, and reload the getters; 0 to reload on SIGHUP only")
+	flag.DurationVar(&unreachableTimeout, "unreachableTimeout", 5*time.Minute, "how long Prometheus can be unreachable before /readyz reports degraded; 0 to disable")
+	flag.StringVar(&promCAFile, "promCAFile", "", "CA bundle to verify the certificate of https Prometheus")
+	flag.StringVar(&promCertFile, "promCertFile", "", "client certificate for mutual TLS with Prometheus")
+	flag.StringVar(&promKeyFile, "promKeyFile", "", "client key for mutual TLS with Prometheus")
+	flag.BoolVar(&promInsecureSkipVerify, "promInsecureSkipVerify", false, "skip verifying the certificate of https Prometheus; only for testing")
+	flag.StringVar(&promBearerTokenFile, "promBearerTokenFile", "", "file of the bearer token sent to Prometheus, such as /var/run/secrets/kubernetes.io/serviceaccount/token")
+	flag.StringVar(&promHeaders, "promHeaders", "", "comma separated headers sent to Prometheus, such as X-Scope-OrgID=tenant-1")
+	flag.IntVar(&promMaxRetries, "promMaxRetries", 0, "retries of a failed Prometheus request (network error, 429 or 5xx)")
+	flag.DurationVar(&promRetryBackoff, "promRetryBackoff", 500*time.Millisecond, "backoff before the first retry of a Prometheus request, doubled for each retry after it")
 	flag.Parse()
 }
 
-func getJobs(mclient *prometheus.RestClient) {
-	msg, err := mclient.GetJobs()
+// parseHeaders parses the headers in "k1=v1,k2=v2"
+func parseHeaders(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); len(kv) < 1 {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid header [%v], expecting name=value", kv)
+		}
+		result[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return result, nil
+}
+
+// loadConfig reads the config file (or the default config),
+// then overrides it with environment variables, and with the flags set explicitly.
+func loadConfig() (*config.Config, error) {
+	conf := config.NewDefaultConfig()
+	if len(configFile) > 0 {
+		var err error
+		if conf, err = config.LoadConfig(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := conf.ApplyEnv(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "promUrl":
+			conf.SetPromURL(prometheusHost)
+		case "promCAFile":
+			conf.FirstPrometheus().CAFile = promCAFile
+		case "promCertFile":
+			conf.FirstPrometheus().CertFile = promCertFile
+		case "promKeyFile":
+			conf.FirstPrometheus().KeyFile = promKeyFile
+		case "promInsecureSkipVerify":
+			conf.FirstPrometheus().InsecureSkipVerify = promInsecureSkipVerify
+		case "promBearerTokenFile":
+			conf.FirstPrometheus().BearerTokenFile = promBearerTokenFile
+		case "promHeaders":
+			var headers map[string]string
+			if headers, err = parseHeaders(promHeaders); err == nil {
+				conf.FirstPrometheus().Headers = headers
+			}
+		case "promMaxRetries":
+			conf.FirstPrometheus().MaxRetries = promMaxRetries
+		case "promRetryBackoff":
+			conf.FirstPrometheus().RetryBackoff.Duration = promRetryBackoff
+		case "port":
+			conf.Server.Port = port
+		case "getterTimeout":
+			conf.Server.GetterTimeout.Duration = getterTimeout
+		case "scrapeInterval":
+			conf.Server.ScrapeInterval.Duration = scrapeInterval
+		case "unreachableTimeout":
+			conf.Server.UnreachableTimeout.Duration = unreachableTimeout
+		case "mergePolicy":
+			conf.App.MergePolicy = mergePolicy
+			conf.VApp.MergePolicy = mergePolicy
+		case "mergeCategories":
+			categories := []string{}
+			for _, c := range strings.Split(mergeOrder, ",") {
+				if c = strings.TrimSpace(c); len(c) > 0 {
+					categories = append(categories, c)
+				}
+			}
+			conf.App.MergeCategories = categories
+			conf.VApp.MergeCategories = categories
+		}
+	})
 	if err != nil {
-		glog.Errorf("Failed to get jobs: %v", err)
-		return
+		return nil, fmt.Errorf("promHeaders: %v", err)
 	}
-	glog.V(1).Infof("jobs: %v", msg)
+
+	return conf, nil
 }
 
-func test_prometheus(mclient *prometheus.RestClient) {
-	glog.V(2).Infof("Begin to test prometheus client...")
-	getJobs(mclient)
-	glog.V(2).Infof("End of testing prometheus client.")
+// test_prometheus checks the Prometheus endpoint of the Alligator once, with the client it scrapes with;
+// the outcome only tells whether Prometheus is reachable, and /readyz waits for a real scrape.
+func test_prometheus(client *ali.Alligator) {
+	glog.V(2).Infof("Begin to test prometheus client of %v...", client.Name())
+	if err := client.Probe(context.Background(), testPrometheusTimeout); err != nil {
+		glog.Warningf("Prometheus of %v is not reachable, the scrapes will fail until it is: %v", client.Name(), err)
+	}
+	glog.V(2).Infof("End of testing prometheus client of %v.", client.Name())
 	return
 }
 
-func main() {
-	parseFlags()
-	pclient, err := prometheus.NewRestClient(prometheusHost)
+func exitOnError(format string, args ...interface{}) {
+	glog.Errorf(format, args...)
+	glog.Flush()
+	os.Exit(1)
+}
+
+// build loads and validates the config, and creates the Alligators with their getters
+func build() (*config.Config, *ali.Alligator, *ali.Alligator, error) {
+	//1. load and validate the config
+	conf, err := loadConfig()
 	if err != nil {
-		glog.Fatalf("Failed to generate client: %v", err)
+		return nil, nil, nil, fmt.Errorf("Failed to load config: %v", err)
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, nil, nil, fmt.Errorf("Invalid config:\n%v", err)
 	}
-	//mclient.SetUser("", "")
-	test_prometheus(pclient)
-
-	factory := addon.NewGetterFactory()
 
-	//1. Application Metrics
-	appClient := ali.NewAlligator(pclient)
-	istioGetter, err := factory.CreateEntityGetter(addon.IstioGetterCategory, "istio.app.metric")
+	//2. Application and Virtual Application Metrics
+	appClient, vappClient, err := conf.BuildAlligators()
 	if err != nil {
-		glog.Errorf("Failed to create Istio App getter: %v", err)
-		return
+		return nil, nil, nil, fmt.Errorf("Failed to create getters: %v", err)
 	}
-	appClient.AddGetter(istioGetter)
 
-	redisGetter, err := factory.CreateEntityGetter(addon.RedisGetterCategory, "redis.app.metric")
-	if err != nil {
-		glog.Errorf("Failed to create Redis App getter: %v", err)
-		return
+	//3. Declarative getters
+	if len(getterConfig) > 0 {
+		factory := addon.NewGetterFactory()
+		getters, err := factory.CreateGenericGetters(getterConfig)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Failed to create getters from %v: %v", getterConfig, err)
+		}
+
+		for _, g := range getters {
+			added := false
+			if g.IsVirtualApp() {
+				added = vappClient.AddGetter(g)
+			} else {
+				added = appClient.AddGetter(g)
+			}
+			if !added {
+				return nil, nil, nil, fmt.Errorf("Duplicated getter in %v: %v", getterConfig, g.Name())
+			}
+		}
 	}
-	appClient.AddGetter(redisGetter)
 
-	//2. Virtual Application Metrics
-	vappClient := ali.NewAlligator(pclient)
-	vappGetter, err := factory.CreateEntityGetter(addon.IstioVAppGetterCategory, "istio.vapp.metric")
+	return conf, appClient, vappClient, nil
+}
+
+func main() {
+	parseFlags()
+
+	conf, appClient, vappClient, err := build()
 	if err != nil {
-		glog.Errorf("Failed to create Istio VApp getter: %v", err)
-		return
+		exitOnError("%v", err)
+	}
+
+	test_prometheus(appClient)
+	test_prometheus(vappClient)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	//4. Reload the getters when the config changes
+	files := []string{}
+	for _, f := range []string{configFile, getterConfig} {
+		if len(f) > 0 {
+			files = append(files, f)
+		}
+	}
+	if len(files) > 0 {
+		reloader := config.NewReloader(conf, appClient, vappClient, build, files, reloadInterval)
+		go reloader.Run(stop)
+	}
+
+	s := server.NewMetricServer(conf.Server.Port, appClient, vappClient)
+	s.SetUnreachableTimeout(conf.Server.UnreachableTimeout.Duration)
+
+	//5. Background scraping
+	if interval := conf.Server.ScrapeInterval.Duration; interval > 0 {
+		appCollector := ali.NewCollector("app", appClient, interval)
+		vappCollector := ali.NewCollector("vapp", vappClient, interval)
+		go appCollector.Run(stop)
+		go vappCollector.Run(stop)
+		s.SetCollectors(appCollector, vappCollector)
 	}
-	vappClient.AddGetter(vappGetter)
 
-	s := server.NewMetricServer(port, appClient, vappClient)
 	s.Run()
 	return
 }
@@ -3,14 +3,157 @@ package addon
 import (
 	"appMetric/pkg/alligator"
 	"fmt"
+	"regexp"
+	"strings"
 )
 
 const (
 	RedisGetterCategory     = "Redis"
 	IstioGetterCategory     = "Istio"
 	IstioVAppGetterCategory = "Istio.VApp"
+	GenericGetterCategory   = "Generic"
+
+	// from the Istio 1.x standard metrics
+	IstioStdGetterCategory     = "Istio.Std"
+	IstioStdVAppGetterCategory = "Istio.Std.VApp"
 )
 
+// Prometheus duration, such as "30s", "3m", "1h"
+var windowRegexp = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|y))+$`)
+
+// DefaultLatencyPercentiles : the latency percentiles computed by Istio getters by default
+var DefaultLatencyPercentiles = []float64{50, 90, 99}
+
+// ValidatePercentiles checks that each percentile is in (0, 100)
+func ValidatePercentiles(percentiles []float64) error {
+	for _, p := range percentiles {
+		if p <= 0 || p >= 100 {
+			return fmt.Errorf("invalid percentile: %v, should be in (0, 100)", p)
+		}
+	}
+	return nil
+}
+
+// ValidateWindow checks whether window is a valid and positive Prometheus duration
+func ValidateWindow(window string) error {
+	if !windowRegexp.MatchString(window) {
+		return fmt.Errorf("invalid window: [%v], should be a Prometheus duration such as 3m", window)
+	}
+	// all the numbers are 0, such as "0s" or "0m0s"
+	if !strings.ContainsAny(window, "123456789") {
+		return fmt.Errorf("invalid window: [%v], should be positive", window)
+	}
+	return nil
+}
+
+// queryWindow returns the window in opts if it is set, otherwise the window of the getter
+func queryWindow(window string, opts *alligator.QueryOptions) string {
+	if opts != nil && len(opts.Window) > 0 {
+		return opts.Window
+	}
+	return window
+}
+
+// GetterConfig : the config of one entity getter
+type GetterConfig struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// the rate window of the queries, such as "3m"; default is "3m"
+	Window string `json:"window,omitempty"`
+
+	// latency percentiles, only for the Istio categories; default is [50, 90, 99], and [] to disable
+	Percentiles []float64 `json:"percentiles,omitempty"`
+
+	// how Redis instances are identified: "ip", "ip:port" or "auto", only for the Redis category; default is "auto"
+	Identity string `json:"identity,omitempty"`
+	// the optional Redis metric groups: "cache", "memory", "clients", "evictions" and "commands",
+	// only for the Redis category; default is all of them, and [] to disable
+	MetricGroups []string `json:"metricGroups,omitempty"`
+	// the number of commands of the "commands" group for each instance; default is 5
+	TopCommands int `json:"topCommands,omitempty"`
+
+	// the declarative definition, only for the Generic category
+	Generic *GenericGetterConfig `json:"generic,omitempty"`
+}
+
+// Validate checks the getter config, without creating the getter
+func (c *GetterConfig) Validate() error {
+	if len(strings.TrimSpace(c.Name)) < 1 {
+		return fmt.Errorf("getter name is empty")
+	}
+
+	if len(c.Window) > 0 {
+		if err := ValidateWindow(c.Window); err != nil {
+			return fmt.Errorf("getter %v: %v", c.Name, err)
+		}
+	}
+
+	if err := ValidatePercentiles(c.Percentiles); err != nil {
+		return fmt.Errorf("getter %v: %v", c.Name, err)
+	}
+	if c.Percentiles != nil && !isIstioCategory(c.Category) {
+		return fmt.Errorf("getter %v: percentiles are only for the Istio categories", c.Name)
+	}
+
+	if len(c.Identity) > 0 {
+		if c.Category != RedisGetterCategory {
+			return fmt.Errorf("getter %v: identity is only for category %v", c.Name, RedisGetterCategory)
+		}
+		if err := ValidateRedisIdentity(c.Identity); err != nil {
+			return fmt.Errorf("getter %v: %v", c.Name, err)
+		}
+	}
+
+	if (c.MetricGroups != nil || c.TopCommands != 0) && c.Category != RedisGetterCategory {
+		return fmt.Errorf("getter %v: metricGroups and topCommands are only for category %v", c.Name, RedisGetterCategory)
+	}
+	if err := ValidateRedisMetricGroups(c.MetricGroups); err != nil {
+		return fmt.Errorf("getter %v: %v", c.Name, err)
+	}
+	if c.TopCommands < 0 {
+		return fmt.Errorf("getter %v: topCommands should not be negative", c.Name)
+	}
+
+	switch c.Category {
+	case RedisGetterCategory, IstioGetterCategory, IstioVAppGetterCategory,
+		IstioStdGetterCategory, IstioStdVAppGetterCategory:
+		if c.Generic != nil {
+			return fmt.Errorf("getter %v: generic definition is only for category %v", c.Name, GenericGetterCategory)
+		}
+		return nil
+	case GenericGetterCategory:
+		if c.Generic == nil {
+			return fmt.Errorf("getter %v: generic definition is missing", c.Name)
+		}
+		if err := c.genericConfig().Validate(); err != nil {
+			return fmt.Errorf("getter %v: %v", c.Name, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("getter %v: unknown category: %v", c.Name, c.Category)
+}
+
+func isIstioCategory(category string) bool {
+	switch category {
+	case IstioGetterCategory, IstioVAppGetterCategory, IstioStdGetterCategory, IstioStdVAppGetterCategory:
+		return true
+	}
+	return false
+}
+
+// the generic definition, with name and window inherited from the getter config
+func (c *GetterConfig) genericConfig() *GenericGetterConfig {
+	conf := *c.Generic
+	if len(conf.Name) < 1 {
+		conf.Name = c.Name
+	}
+	if len(conf.Window) < 1 {
+		conf.Window = c.Window
+	}
+	return &conf
+}
+
 type GetterFactory struct {
 }
 
@@ -30,7 +173,84 @@ func (f *GetterFactory) CreateEntityGetter(category, name string) (alligator.Ent
 		g := newIstioEntityGetter(name)
 		g.SetType(true)
 		return g, nil
+	case IstioStdGetterCategory:
+		return newIstioStdEntityGetter(name, false), nil
+	case IstioStdVAppGetterCategory:
+		return newIstioStdEntityGetter(name, true), nil
 	}
 
 	return nil, fmt.Errorf("Unknown category: %v", category)
 }
+
+// CreateGetter creates an entity getter according to the config
+func (f *GetterFactory) CreateGetter(conf *GetterConfig) (alligator.EntityMetricGetter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	if conf.Category == GenericGetterCategory {
+		return NewGenericEntityGetter(conf.genericConfig())
+	}
+
+	g, err := f.CreateEntityGetter(conf.Category, conf.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch getter := g.(type) {
+	case *IstioEntityGetter:
+		if len(conf.Window) > 0 {
+			getter.SetWindow(conf.Window)
+		}
+		if conf.Percentiles != nil {
+			getter.SetPercentiles(conf.Percentiles)
+		}
+	case *IstioStdEntityGetter:
+		if len(conf.Window) > 0 {
+			getter.SetWindow(conf.Window)
+		}
+		if conf.Percentiles != nil {
+			getter.SetPercentiles(conf.Percentiles)
+		}
+	case *RedisEntityGetter:
+		if len(conf.Window) > 0 {
+			getter.SetWindow(conf.Window)
+		}
+		if len(conf.Identity) > 0 {
+			if err := getter.SetIdentity(conf.Identity); err != nil {
+				return nil, err
+			}
+		}
+		if conf.MetricGroups != nil {
+			if err := getter.SetMetricGroups(conf.MetricGroups); err != nil {
+				return nil, err
+			}
+		}
+		if conf.TopCommands > 0 {
+			if err := getter.SetTopCommands(conf.TopCommands); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// CreateGenericGetters creates one GenericEntityGetter for each entry in the getter config file, which is JSON only
+func (f *GetterFactory) CreateGenericGetters(fname string) ([]*GenericEntityGetter, error) {
+	confs, err := LoadGenericGetterConfigs(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*GenericEntityGetter{}
+	for _, conf := range confs {
+		g, err := NewGenericEntityGetter(conf)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, g)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,345 @@
+package addon
+
+import (
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	xfire "github.com/songbinliu/xfire/pkg/prometheus"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	ApplicationEntity        = "Application"
+	VirtualApplicationEntity = "VirtualApplication"
+)
+
+// GenericGetterConfig : the declarative definition of an entity getter.
+// For example, to get the entities from memcached_exporter:
+//
+//	{
+//	  "name": "memcached.app.metric",
+//	  "category": "Memcached",
+//	  "queries": {"tps": "rate(memcached_commands_total[{{.Window}}])"},
+//	  "ipLabel": "instance"
+//	}
+type GenericGetterConfig struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// Application or VirtualApplication; default is Application
+	EntityType string `json:"entityType,omitempty"`
+
+	// metric name (such as "tps", "latency") -> PromQL template;
+	// the template can refer to the rate window by {{.Window}}
+	Queries map[string]string `json:"queries"`
+
+	// the Prometheus label holding the UID of the entity; default is the IP
+	UIDLabel string `json:"uidLabel,omitempty"`
+	// the Prometheus label holding the IP (or "IP:port") of the entity
+	IPLabel string `json:"ipLabel,omitempty"`
+	// entity label -> the Prometheus label to copy from
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// the rate window, such as "3m"; default is "3m"
+	Window string `json:"window,omitempty"`
+}
+
+// GenericGetterConfigList : the content of a getter config file
+type GenericGetterConfigList struct {
+	Getters []*GenericGetterConfig `json:"getters"`
+}
+
+// LoadGenericGetterConfigs reads and validates the getter configs from a json file
+func LoadGenericGetterConfigs(fname string) ([]*GenericGetterConfig, error) {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	var confs GenericGetterConfigList
+	if err := json.Unmarshal(content, &confs); err != nil {
+		return nil, fmt.Errorf("Failed to parse getter config file %v: %v", fname, err)
+	}
+
+	for i, conf := range confs.Getters {
+		if err := conf.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid getter[%d] in %v: %v", i, fname, err)
+		}
+	}
+
+	return confs.Getters, nil
+}
+
+// Validate checks the required fields and the query templates
+func (c *GenericGetterConfig) Validate() error {
+	if len(strings.TrimSpace(c.Name)) < 1 {
+		return fmt.Errorf("name is empty")
+	}
+
+	if len(strings.TrimSpace(c.Category)) < 1 {
+		return fmt.Errorf("category of %v is empty", c.Name)
+	}
+
+	if _, err := c.getEntityType(); err != nil {
+		return fmt.Errorf("%v: %v", c.Name, err)
+	}
+
+	if len(c.Queries) < 1 {
+		return fmt.Errorf("no queries for %v", c.Name)
+	}
+
+	for k, v := range c.Queries {
+		if _, err := newQueryTemplate(k, v); err != nil {
+			return fmt.Errorf("%v: invalid query for %v: %v", c.Name, k, err)
+		}
+	}
+
+	if len(c.UIDLabel) < 1 && len(c.IPLabel) < 1 {
+		return fmt.Errorf("%v: either uidLabel or ipLabel should be set", c.Name)
+	}
+
+	if len(c.Window) > 0 {
+		if err := ValidateWindow(c.Window); err != nil {
+			return fmt.Errorf("%v: %v", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *GenericGetterConfig) getEntityType() (int32, error) {
+	switch c.EntityType {
+	case "", ApplicationEntity:
+		return inter.ApplicationType, nil
+	case VirtualApplicationEntity:
+		return inter.VirtualApplicationType, nil
+	}
+
+	return 0, fmt.Errorf("unknown entityType: %v, vs. %v|%v", c.EntityType, ApplicationEntity, VirtualApplicationEntity)
+}
+
+// the values to fill in the query templates
+type queryParams struct {
+	Window string
+}
+
+func newQueryTemplate(name, query string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(query)
+}
+
+// GenericEntityGetter : get the entities according to a GenericGetterConfig
+type GenericEntityGetter struct {
+	name     string
+	category string
+	etype    int32
+	conf     *GenericGetterConfig
+	window   string
+
+	// metric name -> query template, and the query generated with window
+	templates map[string]*template.Template
+	queries   map[string]string
+}
+
+// ensure GenericEntityGetter implement the requisite interfaces
+var _ alligator.OptionsGetter = &GenericEntityGetter{}
+
+func NewGenericEntityGetter(conf *GenericGetterConfig) (*GenericEntityGetter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	etype, _ := conf.getEntityType()
+	g := &GenericEntityGetter{
+		name:     conf.Name,
+		category: conf.Category,
+		etype:    etype,
+		conf:     conf,
+		window:   turboMetricDuration,
+
+		templates: make(map[string]*template.Template),
+	}
+
+	if len(conf.Window) > 0 {
+		g.window = conf.Window
+	}
+	for k, v := range conf.Queries {
+		g.templates[k], _ = newQueryTemplate(k, v)
+	}
+
+	queries, err := g.generateQueries(g.window)
+	if err != nil {
+		return nil, err
+	}
+	g.queries = queries
+	for k, v := range queries {
+		glog.V(3).Infof("%v query for %v: %v", g.name, k, v)
+	}
+
+	return g, nil
+}
+
+// generateQueries fills in the query templates with the window
+func (g *GenericEntityGetter) generateQueries(window string) (map[string]string, error) {
+	result := make(map[string]string)
+	params := &queryParams{Window: window}
+
+	for k, tmp := range g.templates {
+		var buffer bytes.Buffer
+		if err := tmp.Execute(&buffer, params); err != nil {
+			return nil, fmt.Errorf("%v: failed to generate query for %v: %v", g.name, k, err)
+		}
+		result[k] = buffer.String()
+	}
+	return result, nil
+}
+
+func (g *GenericEntityGetter) Name() string {
+	return g.name
+}
+
+func (g *GenericEntityGetter) Category() string {
+	return g.category
+}
+
+// Window returns the rate window of the queries
+func (g *GenericEntityGetter) Window() string {
+	return g.window
+}
+
+// IsVirtualApp returns true if the getter generates VirtualApplication entities
+func (g *GenericEntityGetter) IsVirtualApp() bool {
+	return g.etype == inter.VirtualApplicationType
+}
+
+// GetEntityMetric runs the query of each metric; it fails only if all the queries failed.
+func (g *GenericEntityGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	return g.GetEntityMetricWithOptions(client, nil)
+}
+
+// GetEntityMetricWithOptions is GetEntityMetric, with the rate window overridden by opts
+func (g *GenericEntityGetter) GetEntityMetricWithOptions(client promclient.MetricClient, opts *alligator.QueryOptions) ([]*inter.EntityMetric, error) {
+	result := []*inter.EntityMetric{}
+	midResult := make(map[string]*inter.EntityMetric)
+
+	queries := g.queries
+	if window := queryWindow(g.window, opts); window != g.window {
+		var err error
+		if queries, err = g.generateQueries(window); err != nil {
+			return result, err
+		}
+	}
+
+	names := []string{}
+	for k := range queries {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	//1. the queries are sent concurrently
+	inputs := []xfire.RequestInput{}
+	for _, name := range names {
+		input := xfire.NewBasicInput()
+		input.SetQuery(queries[name])
+		inputs = append(inputs, input)
+	}
+	results := getMetrics(client, inputs...)
+
+	//2. merge the results in the order of names, so the labels of the entities are deterministic
+	var lastErr error
+	for i, name := range names {
+		if err := results[i].err; err != nil {
+			glog.Errorf("%v failed to get %v metrics: %v", g.name, name, err)
+			lastErr = err
+			continue
+		}
+		g.addEntity(results[i].dat, midResult, name)
+	}
+
+	if lastErr != nil && len(midResult) < 1 {
+		return result, lastErr
+	}
+
+	for _, v := range midResult {
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+func (g *GenericEntityGetter) addEntity(mdat []xfire.MetricData, result map[string]*inter.EntityMetric, key string) {
+	for _, dat := range mdat {
+		metric, ok := dat.(*xfire.BasicMetricData)
+		if !ok {
+			glog.Errorf("Type assertion failed for[%v].", key)
+			continue
+		}
+
+		uid, ip, err := g.getEntityID(metric.Labels)
+		if err != nil {
+			glog.Errorf("%v failed to get entity id: %v", g.name, err)
+			continue
+		}
+
+		entity, ok := result[uid]
+		if !ok {
+			entity = inter.NewEntityMetric(uid, g.etype)
+			if len(ip) > 0 {
+				entity.SetLabel(inter.IP, ip)
+			}
+			entity.SetLabel(inter.Category, g.category)
+			result[uid] = entity
+		}
+
+		//the series of different metrics may carry different labels
+		for k, v := range g.conf.Labels {
+			if _, exist := entity.Labels[k]; exist {
+				continue
+			}
+			if value, exist := metric.Labels[v]; exist {
+				entity.SetLabel(k, value)
+			}
+		}
+
+		entity.SetMetric(key, metric.GetValue())
+	}
+}
+
+// getEntityID returns the UID, and the IP (if ipLabel is set) of the entity
+func (g *GenericEntityGetter) getEntityID(labels map[string]string) (string, string, error) {
+	ip := ""
+	if len(g.conf.IPLabel) > 0 {
+		addr, ok := labels[g.conf.IPLabel]
+		if !ok {
+			return "", "", fmt.Errorf("label %v is not found", g.conf.IPLabel)
+		}
+		ip = parseHost(addr)
+		if len(ip) < 1 {
+			return "", "", fmt.Errorf("illegal IP: [%v]", addr)
+		}
+	}
+
+	if len(g.conf.UIDLabel) < 1 {
+		return ip, ip, nil
+	}
+
+	uid, ok := labels[g.conf.UIDLabel]
+	if !ok || len(strings.TrimSpace(uid)) < 1 {
+		return "", "", fmt.Errorf("label %v is not found", g.conf.UIDLabel)
+	}
+	return strings.TrimSpace(uid), ip, nil
+}
+
+// parseHost returns the host part of "host:port", or addr itself if it has no port
+func parseHost(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,202 @@
+package addon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
+)
+
+// the parts of a combined query, see combineQueries
+var combinedPartRegexp = regexp.MustCompile(`label_replace\((.+?), "` + metricNameLabel + `", "([^"]*)", "", ""\)`)
+
+// fakeResult returns the result of the query. For a combined query, it is the results of the parts,
+// labeled with their metric names; the unknown parts are empty, as the absent series in Prometheus.
+// A combined query fails only if all its parts are unknown.
+func fakeResult(results map[string]string, query string) (string, bool) {
+	if result, ok := results[query]; ok {
+		return result, true
+	}
+
+	known := false
+	items := []string{}
+	for _, part := range combinedPartRegexp.FindAllStringSubmatch(query, -1) {
+		result, ok := results[part[1]]
+		if !ok {
+			continue
+		}
+		known = true
+
+		var series []map[string]interface{}
+		if err := json.Unmarshal([]byte("["+result+"]"), &series); err != nil {
+			return "", false
+		}
+		for _, s := range series {
+			metric, _ := s["metric"].(map[string]interface{})
+			if metric == nil {
+				metric = make(map[string]interface{})
+				s["metric"] = metric
+			}
+			metric[metricNameLabel] = part[2]
+			content, _ := json.Marshal(s)
+			items = append(items, string(content))
+		}
+	}
+	return strings.Join(items, ","), known
+}
+
+// a stand-in Prometheus server: query -> vector result
+func newFakePrometheus(results map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		result, ok := fakeResult(results, query)
+		if !ok {
+			fmt.Fprintf(w, `{"status":"error","errorType":"bad_data","error":"unknown query: %v"}`, query)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%v]}}`, result)
+	}))
+}
+
+// a stand-in Prometheus server for range queries: query -> matrix result
+func newFakeRangePrometheus(results map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		result, ok := fakeResult(results, query)
+		if !ok || !strings.HasSuffix(r.URL.Path, "/query_range") {
+			fmt.Fprintf(w, `{"status":"error","errorType":"bad_data","error":"unknown query: %v"}`, query)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%v]}}`, result)
+	}))
+}
+
+func newGenericConf() *GenericGetterConfig {
+	return &GenericGetterConfig{
+		Name:     "memcached.app.metric",
+		Category: "Memcached",
+		Queries: map[string]string{
+			inter.TPS:     "rate(memcached_commands_total[{{.Window}}])",
+			inter.Latency: "memcached_latency_ms",
+		},
+		IPLabel: "instance",
+		Labels:  map[string]string{inter.Port: "port"},
+	}
+}
+
+func TestGenericGetterConfig_Validate(t *testing.T) {
+	conf := newGenericConf()
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Failed to validate config: %v", err)
+	}
+
+	bad := []func(c *GenericGetterConfig){
+		func(c *GenericGetterConfig) { c.Name = "" },
+		func(c *GenericGetterConfig) { c.Category = " " },
+		func(c *GenericGetterConfig) { c.EntityType = "Pod" },
+		func(c *GenericGetterConfig) { c.Queries = nil },
+		func(c *GenericGetterConfig) { c.Queries[inter.TPS] = "rate(x[{{.Window}])" },
+		func(c *GenericGetterConfig) { c.IPLabel = "" },
+	}
+
+	for i, f := range bad {
+		conf := newGenericConf()
+		f(conf)
+		if err := conf.Validate(); err == nil {
+			t.Errorf("[%d] validation should have failed: %+v", i, conf)
+		}
+	}
+}
+
+func TestGenericEntityGetter_GetEntityMetric(t *testing.T) {
+	tps := fmt.Sprintf("rate(memcached_commands_total[%v])", turboMetricDuration)
+	server := newFakePrometheus(map[string]string{
+		tps: `{"metric":{"instance":"10.0.2.3:11211","port":"11211"},"value":[1524246000,"12.5"]},
+			{"metric":{"instance":"10.0.2.4:11211","port":"11211"},"value":[1524246000,"3"]}`,
+		"memcached_latency_ms": `{"metric":{"instance":"10.0.2.3:11211"},"value":[1524246000,"1.5"]}`,
+	})
+	defer server.Close()
+
+	client, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	g, err := NewGenericEntityGetter(newGenericConf())
+	if err != nil {
+		t.Errorf("Failed to create getter: %v", err)
+		return
+	}
+
+	result, err := g.GetEntityMetric(client)
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result) != 2 {
+		t.Errorf("Expected 2 entities, got %d", len(result))
+		return
+	}
+
+	for _, e := range result {
+		if e.Labels[inter.Category] != "Memcached" || e.Labels[inter.Port] != "11211" || e.Type != inter.ApplicationType {
+			t.Errorf("Wrong labels: %+v", e)
+		}
+
+		if e.UID != "10.0.2.3" {
+			continue
+		}
+
+		if e.Labels[inter.IP] != "10.0.2.3" || e.Metrics[inter.TPS] != 12.5 || e.Metrics[inter.Latency] != 1.5 {
+			t.Errorf("Wrong entity: %+v", e)
+		}
+	}
+}
+
+func TestLoadGenericGetterConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getters")
+	if err != nil {
+		t.Errorf("Failed to create temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "getters.json")
+	content := `{"getters": [{"name": "mc", "category": "Memcached", "entityType": "VirtualApplication",
+		"queries": {"tps": "rate(x[{{.Window}}])"}, "uidLabel": "service"}]}`
+	if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Errorf("Failed to write config: %v", err)
+		return
+	}
+
+	confs, err := LoadGenericGetterConfigs(fname)
+	if err != nil {
+		t.Errorf("Failed to load config: %v", err)
+		return
+	}
+
+	if len(confs) != 1 || confs[0].Name != "mc" {
+		t.Errorf("Wrong configs: %+v", confs)
+	}
+
+	content = strings.Replace(content, `"category": "Memcached",`, "", 1)
+	if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Errorf("Failed to write config: %v", err)
+		return
+	}
+
+	if _, err := LoadGenericGetterConfigs(fname); err == nil {
+		t.Errorf("Loading config without category should have failed.")
+	}
+}
@@ -3,11 +3,15 @@ package addon
 import (
 	"appMetric/pkg/alligator"
 	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
 	"bytes"
 	"fmt"
 	"github.com/golang/glog"
 	pclient "github.com/songbinliu/xfire/pkg/prometheus"
 	"math"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +25,9 @@ const (
 	turbo_POD_LATENCY_COUNT = "istio_turbo_pod_latency_time_ms_count"
 	turbo_POD_REQUEST_COUNT = "istio_turbo_pod_request_count"
 
+	turbo_SVC_LATENCY_BUCKET = "istio_turbo_service_latency_time_ms_bucket"
+	turbo_POD_LATENCY_BUCKET = "istio_turbo_pod_latency_time_ms_bucket"
+
 	turboMetricDuration = "3m"
 
 	k8sPrefix    = "kubernetes://"
@@ -36,19 +43,24 @@ const (
 )
 
 type IstioEntityGetter struct {
-	name  string
-	query *istioQuery
-	etype int //Pod(Application), or Service
+	name   string
+	etype  int //Pod(Application), or Service
+	window string
+
+	// latency percentiles computed from the histogram buckets, such as 50, 90, 99
+	percentiles []float64
 }
 
 // ensure IstioEntityGetter implement the requisite interfaces
-var _ alligator.EntityMetricGetter = &IstioEntityGetter{}
+var _ alligator.OptionsGetter = &IstioEntityGetter{}
 
 func newIstioEntityGetter(name string) *IstioEntityGetter {
 	return &IstioEntityGetter{
-		name:  name,
-		etype: podType,
-		query: newIstioQuery(),
+		name:   name,
+		etype:  podType,
+		window: turboMetricDuration,
+
+		percentiles: DefaultLatencyPercentiles,
 	}
 }
 
@@ -64,6 +76,21 @@ func (istio *IstioEntityGetter) SetType(isVirtualApp bool) {
 	}
 }
 
+// SetWindow sets the rate window of the queries, such as "3m"
+func (istio *IstioEntityGetter) SetWindow(window string) {
+	istio.window = window
+}
+
+// Window returns the rate window of the queries
+func (istio *IstioEntityGetter) Window() string {
+	return istio.window
+}
+
+// SetPercentiles sets the latency percentiles to compute, such as 50, 90, 99
+func (istio *IstioEntityGetter) SetPercentiles(percentiles []float64) {
+	istio.percentiles = percentiles
+}
+
 func (istio *IstioEntityGetter) Category() string {
 	if istio.etype == podType {
 		return "Istio"
@@ -72,36 +99,117 @@ func (istio *IstioEntityGetter) Category() string {
 	return "Istio.VApp"
 }
 
-func (istio *IstioEntityGetter) GetEntityMetric(client *pclient.RestClient) ([]*inter.EntityMetric, error) {
+func (istio *IstioEntityGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	return istio.GetEntityMetricWithOptions(client, nil)
+}
+
+// GetEntityMetricWithOptions gets the entity metrics, with the rate window overridden by opts.
+// TPS and latency are got by one query, and the percentiles by another; the queries are sent concurrently.
+func (istio *IstioEntityGetter) GetEntityMetricWithOptions(client promclient.MetricClient, opts *alligator.QueryOptions) ([]*inter.EntityMetric, error) {
 	result := []*inter.EntityMetric{}
+	window := queryWindow(istio.window, opts)
+	pod := istio.etype == podType
+	matcher := ""
+	if opts != nil {
+		matcher = getIstioMatcher(pod, opts.UID)
+	}
 
-	if istio.etype == podType {
-		istio.query.SetQueryType(podTPS)
-	} else {
-		istio.query.SetQueryType(svcTPS)
+	inputs := []pclient.RequestInput{newIstioQuery(pod, window, matcher), newIstioResponseCodeQuery(pod, window, matcher)}
+	if len(istio.percentiles) > 0 {
+		inputs = append(inputs, newIstioPercentileQuery(pod, istio.percentiles, window, matcher))
 	}
-	tpsDat, err := client.GetMetrics(istio.query)
-	if err != nil {
-		glog.Errorf("Failed to get Pod Transaction metrics: %v", err)
+	results := getMetrics(client, inputs...)
+
+	//1. TPS and latency are required
+	if err := results[0].err; err != nil {
+		glog.Errorf("Failed to get Istio TPS and Latency metrics: %v", err)
 		return result, err
 	}
+	dat := splitByMetric(results[0].dat)
+	glog.V(4).Infof("len(TPS)=%d, len(Latency)=%d", len(dat[inter.TPS]), len(dat[inter.Latency]))
 
-	if istio.etype == podType {
-		istio.query.SetQueryType(podLatency)
+	midresult := istio.mergeTPSandLatency(dat[inter.TPS], dat[inter.Latency])
+
+	//2. request rate by response code is optional: a failed query does not fail the getter
+	if err := results[1].err; err != nil {
+		glog.Errorf("Failed to get request rate by response code: %v", err)
 	} else {
-		istio.query.SetQueryType(svcLatency)
+		istio.addResponseCodeMetrics(midresult, results[1].dat)
+	}
+
+	//3. latency percentiles are optional too
+	if len(results) > 2 {
+		if err := results[2].err; err != nil {
+			glog.Errorf("Failed to get latency percentiles %v: %v", istio.percentiles, err)
+		} else {
+			dat := splitByMetric(results[2].dat)
+			for _, p := range istio.percentiles {
+				key := inter.LatencyPercentile(p)
+				istio.addMetrics(midresult, dat[key], key)
+			}
+		}
 	}
-	latencyDat, err := client.GetMetrics(istio.query)
-	if err != nil {
-		glog.Errorf("Failed to get pod Latency metrics: %v", err)
-		return result, err
+
+	for _, entity := range midresult {
+		result = append(result, entity)
 	}
 
-	glog.V(4).Infof("len(TPS)=%d, len(Latency)=%d", len(tpsDat), len(latencyDat))
+	return result, nil
+}
 
-	result = istio.mergeTPSandLatency(tpsDat, latencyDat)
+// addResponseCodeMetrics sets the total request rate, success (2xx) rate, 4xx rate, 5xx rate,
+// and error ratio ((4xx + 5xx) / total) of the entities.
+func (istio *IstioEntityGetter) addResponseCodeMetrics(midresult map[string]*inter.EntityMetric, mdat []pclient.MetricData) {
+	type rates struct {
+		total, success, c4xx, c5xx float64
+		metric                     *istioMetricData
+	}
+	midrates := make(map[string]*rates)
 
-	return result, nil
+	for _, dat := range mdat {
+		metric, ok := dat.(*istioMetricData)
+		if !ok {
+			glog.Errorf("Type assertion failed for response code: not an IstioMetricData")
+			continue
+		}
+
+		r, exist := midrates[metric.uuid]
+		if !exist {
+			r = &rates{metric: metric}
+			midrates[metric.uuid] = r
+		}
+
+		v := metric.GetValue()
+		r.total += v
+		switch {
+		case strings.HasPrefix(metric.code, "2"):
+			r.success += v
+		case strings.HasPrefix(metric.code, "4"):
+			r.c4xx += v
+		case strings.HasPrefix(metric.code, "5"):
+			r.c5xx += v
+		}
+	}
+
+	//reuse addMetrics to create the entities not found
+	for uid, r := range midrates {
+		metrics := map[string]float64{
+			inter.RequestRate:  r.total,
+			inter.SuccessRate:  r.success,
+			inter.Error4xxRate: r.c4xx,
+			inter.Error5xxRate: r.c5xx,
+		}
+		if r.total > 0 {
+			metrics[inter.ErrorRatio] = (r.c4xx + r.c5xx) / r.total
+		}
+
+		for k, v := range metrics {
+			dat := *r.metric
+			dat.Value = v
+			istio.addMetrics(midresult, []pclient.MetricData{&dat}, k)
+		}
+		glog.V(5).Infof("uid=%v, %+v", uid, midresult[uid])
+	}
 }
 
 func (istio *IstioEntityGetter) assignMetric(entity *inter.EntityMetric, metric *istioMetricData) {
@@ -113,64 +221,41 @@ func (istio *IstioEntityGetter) assignMetric(entity *inter.EntityMetric, metric
 	entity.SetLabel(inter.Category, istio.Category())
 }
 
-func (istio *IstioEntityGetter) mergeTPSandLatency(tpsDat, latencyDat []pclient.MetricData) []*inter.EntityMetric {
-	result := []*inter.EntityMetric{}
+func (istio *IstioEntityGetter) mergeTPSandLatency(tpsDat, latencyDat []pclient.MetricData) map[string]*inter.EntityMetric {
 	midresult := make(map[string]*inter.EntityMetric)
+	istio.addMetrics(midresult, tpsDat, inter.TPS)
+	istio.addMetrics(midresult, latencyDat, inter.Latency)
+
+	glog.V(4).Infof("len(midResult) = %d", len(midresult))
+	return midresult
+}
+
+// addMetrics sets the metric of the entities, creating the entities not found in midresult
+func (istio *IstioEntityGetter) addMetrics(midresult map[string]*inter.EntityMetric, mdat []pclient.MetricData, key string) {
 	etype := inter.ApplicationType
 	if istio.etype == svcType {
 		etype = inter.VirtualApplicationType
 	}
 
-	for _, dat := range tpsDat {
-		tps, ok := dat.(*istioMetricData)
-		if !ok {
-			glog.Errorf("Type assertion failed for TPS: not an IstioMetricData")
-			continue
-		}
-
-		entity := inter.NewEntityMetric(tps.uuid, etype)
-
-		istio.assignMetric(entity, tps)
-		entity.SetMetric(inter.TPS, tps.GetValue())
-		midresult[entity.UID] = entity
-		glog.V(5).Infof("uid=%v,uid2=%v, %+v", entity.UID, tps.uuid, entity)
-	}
-
-	for _, dat := range latencyDat {
-		latency, ok := dat.(*istioMetricData)
+	for _, dat := range mdat {
+		metric, ok := dat.(*istioMetricData)
 		if !ok {
-			glog.Errorf("Type assertion failed for Latency: not an IstioMetricData")
+			glog.Errorf("Type assertion failed for %v: not an IstioMetricData", key)
 			continue
 		}
 
-		entity, exist := midresult[latency.uuid]
+		entity, exist := midresult[metric.uuid]
 		if !exist {
-			glog.V(3).Infof("Some entity does not have TPS metric: %+v", latency)
-			entity = inter.NewEntityMetric(latency.uuid, etype)
+			if key != inter.TPS {
+				glog.V(3).Infof("Some entity does not have TPS metric: %+v", metric)
+			}
+			entity = inter.NewEntityMetric(metric.uuid, etype)
 			midresult[entity.UID] = entity
-			istio.assignMetric(entity, latency)
+			istio.assignMetric(entity, metric)
 		}
-		entity.SetMetric(inter.Latency, latency.GetValue())
+		entity.SetMetric(key, metric.GetValue())
 		glog.V(5).Infof("uid=%v, %+v", entity.UID, entity)
 	}
-
-	glog.V(4).Infof("len(midResult) = %d", len(midresult))
-
-	for _, entity := range midresult {
-		result = append(result, entity)
-	}
-
-	return result
-}
-
-// IstioQuery : generate queries for Istio-Prometheus metrics
-// qtype 0: pod.request-per-second
-//       1: pod.latency
-//       2: service.request-per-second
-//       3: service.latency
-type istioQuery struct {
-	qtype    int
-	queryMap map[int]string
 }
 
 // IstioMetricData : hold the result of Istio-Prometheus data
@@ -178,74 +263,66 @@ type istioMetricData struct {
 	Labels map[string]string `json:"labels"`
 	Value  float64           `json:"value"`
 	uuid   string
-	dtype  int //0,1,2,3 same as qtype
+	code   string //response code, if grouped by it
+	metric string //name of the metric, if from a combined query
+	dtype  int    //podTPS or svcTPS, for parsing the UID of pods or services
 }
 
-// NewIstioQuery : create a new IstioQuery
-func newIstioQuery() *istioQuery {
+// istioQuery : an immutable query for pods (dtype=podTPS) or services (dtype=svcTPS)
+type istioQuery struct {
+	query string
+	dtype int
+}
+
+func newIstioTypedQuery(pod bool, query string) *istioQuery {
 	q := &istioQuery{
-		qtype:    0,
-		queryMap: make(map[int]string),
+		query: query,
+		dtype: podTPS,
+	}
+	if !pod {
+		q.dtype = svcTPS
 	}
-
-	isPod := true
-	q.queryMap[podTPS] = getRPSExp(isPod)
-	q.queryMap[1] = getLatencyExp(isPod)
-	isPod = false
-	q.queryMap[2] = getRPSExp(isPod)
-	q.queryMap[3] = getLatencyExp(isPod)
-
 	return q
 }
 
-func (q *istioQuery) SetQueryType(t int) error {
-	if t < 0 {
-		err := fmt.Errorf("Invalid query type: %d, vs 0|1|2|3", t)
-		glog.Error(err)
-		return err
-	}
+// newIstioQuery : query for both TPS and latency of pods or services, with the rate window;
+// the matcher narrows it to one entity, empty for all of them (see getIstioMatcher)
+func newIstioQuery(pod bool, window, matcher string) *istioQuery {
+	return newIstioTypedQuery(pod, combineQueries([]namedQuery{
+		{inter.TPS, getRPSExp(pod, window, matcher)},
+		{inter.Latency, getLatencyExp(pod, window, matcher)},
+	}))
+}
 
-	if t > len(q.queryMap) {
-		err := fmt.Errorf("Invalid query type: %d, vs 0|1|2|3", t)
-		glog.Error(err)
-		return err
+// query for the latency percentiles of pods or services, named by inter.LatencyPercentile
+func newIstioPercentileQuery(pod bool, percentiles []float64, window, matcher string) *istioQuery {
+	queries := []namedQuery{}
+	for _, p := range percentiles {
+		queries = append(queries, namedQuery{inter.LatencyPercentile(p), getLatencyPercentileExp(pod, p, window, matcher)})
 	}
-
-	q.qtype = t
-
-	return nil
+	return newIstioTypedQuery(pod, combineQueries(queries))
 }
 
-func (q *istioQuery) GetQueryType() int {
-	return q.qtype
+// query for the request rate of each response code of pods or services
+func newIstioResponseCodeQuery(pod bool, window, matcher string) *istioQuery {
+	return newIstioTypedQuery(pod, getResponseCodeRateExp(pod, window, matcher))
 }
 
 func (q *istioQuery) GetQuery() string {
-	return q.queryMap[q.qtype]
+	return q.query
 }
 
 func (q *istioQuery) Parse(m *pclient.RawMetric) (pclient.MetricData, error) {
 	d := newIstioMetricData()
-	d.SetType(q.qtype)
+	d.SetType(q.dtype)
 	if err := d.Parse(m); err != nil {
-		glog.Errorf("Failed to parse metrics: %s", err)
+		glog.V(3).Infof("Failed to parse metrics: %s", err)
 		return nil, err
 	}
 
 	return d, nil
 }
 
-func (q *istioQuery) String() string {
-	var buffer bytes.Buffer
-
-	for k, v := range q.queryMap {
-		tmp := fmt.Sprintf("qtype:%d, query=%s", k, v)
-		buffer.WriteString(tmp)
-	}
-
-	return buffer.String()
-}
-
 func newIstioMetricData() *istioMetricData {
 	return &istioMetricData{
 		Labels: make(map[string]string),
@@ -259,6 +336,8 @@ func (d *istioMetricData) Parse(m *pclient.RawMetric) error {
 	}
 
 	labels := m.Labels
+	d.code = labels["response_code"]
+	d.metric = labels[metricNameLabel]
 
 	//1. pod/svc Name
 	v, ok := labels["destination_uid"]
@@ -337,6 +416,11 @@ func (d *istioMetricData) SetType(t int) {
 	d.dtype = t
 }
 
+// MetricName returns the name of the metric, if the data is from a combined query
+func (d *istioMetricData) MetricName() string {
+	return d.metric
+}
+
 func (d *istioMetricData) GetEntityID() string {
 	return d.uuid
 }
@@ -355,7 +439,38 @@ func (d *istioMetricData) String() string {
 	return buffer.String()
 }
 
-func getLatencyExp(pod bool) string {
+// getIstioMatcher returns the label matchers narrowing the queries to the entity of uid;
+// the UID of a pod is its IP, and the one of a service is "<namespace>/<name>" (see istioMetricData.Parse).
+// It is empty if the uid is not in these forms, so the queries are not narrowed.
+func getIstioMatcher(pod bool, uid string) string {
+	if len(uid) < 1 {
+		return ""
+	}
+
+	if pod {
+		if net.ParseIP(uid) == nil {
+			return ""
+		}
+		return fmt.Sprintf("destination_ip=%v", strconv.Quote(uid))
+	}
+
+	// "productpage.default.svc.cluster.local" for "default/productpage"
+	items := strings.SplitN(uid, "/", 2)
+	if len(items) != 2 || len(items[0]) < 1 || len(items[1]) < 1 {
+		return ""
+	}
+	return fmt.Sprintf("destination_uid=~%v", strconv.Quote(regexp.QuoteMeta(items[1]+"."+items[0]+".svc")+"(\\..*)?"))
+}
+
+// istioSelector returns the metric name with the filter, such as name{response_code="200"}
+func istioSelector(name, filter string) string {
+	if len(filter) < 1 {
+		return name
+	}
+	return fmt.Sprintf("%v{%v}", name, filter)
+}
+
+func getLatencyExp(pod bool, du, matcher string) string {
 	name_sum := ""
 	name_count := ""
 	if pod {
@@ -365,23 +480,49 @@ func getLatencyExp(pod bool) string {
 		name_sum = turbo_SVC_LATENCY_SUM
 		name_count = turbo_SVC_LATENCY_COUNT
 	}
-	du := turboMetricDuration
 
-	result := fmt.Sprintf("rate(%v{response_code=\"200\"}[%v])/rate(%v{response_code=\"200\"}[%v])", name_sum, du, name_count, du)
+	filter := withMatcher(`response_code="200"`, matcher)
+	result := fmt.Sprintf("rate(%v[%v])/rate(%v[%v])", istioSelector(name_sum, filter), du, istioSelector(name_count, filter), du)
+	return result
+}
+
+// exp = histogram_quantile(0.9, sum(rate(turbo_pod_latency_time_ms_bucket{response_code="200"}[3m])) by (le, destination_uid, destination_ip))
+func getLatencyPercentileExp(pod bool, percentile float64, du, matcher string) string {
+	name := turbo_SVC_LATENCY_BUCKET
+	labels := "le, destination_uid"
+	if pod {
+		name = turbo_POD_LATENCY_BUCKET
+		labels = "le, destination_uid, destination_ip"
+	}
+
+	q := strconv.FormatFloat(percentile/100.0, 'f', -1, 64)
+	result := fmt.Sprintf("histogram_quantile(%v, sum(rate(%v[%v])) by (%v))", q, istioSelector(name, withMatcher(`response_code="200"`, matcher)), du, labels)
+	return result
+}
+
+// exp = sum(rate(turbo_pod_request_count[3m])) by (destination_uid, destination_ip, response_code)
+func getResponseCodeRateExp(pod bool, du, matcher string) string {
+	name := turbo_SVC_REQUEST_COUNT
+	labels := "destination_uid, response_code"
+	if pod {
+		name = turbo_POD_REQUEST_COUNT
+		labels = "destination_uid, destination_ip, response_code"
+	}
+
+	result := fmt.Sprintf("sum(rate(%v[%v])) by (%v)", istioSelector(name, matcher), du, labels)
 	return result
 }
 
 // exp = rate(turbo_request_count{response_code="200",  source_service="unknown"}[3m])
-func getRPSExp(pod bool) string {
+func getRPSExp(pod bool, du, matcher string) string {
 	name_count := ""
 	if pod {
 		name_count = turbo_POD_REQUEST_COUNT
 	} else {
 		name_count = turbo_SVC_REQUEST_COUNT
 	}
-	du := turboMetricDuration
 
-	result := fmt.Sprintf("rate(%v{response_code=\"200\"}[%v])", name_count, du)
+	result := fmt.Sprintf("rate(%v[%v])", istioSelector(name_count, withMatcher(`response_code="200"`, matcher)), du)
 	return result
 }
 
@@ -423,7 +564,7 @@ func convertSVCUID(uid string) (string, error) {
 	items := strings.Split(uid, ".")
 	if len(items) < 3 {
 		err := fmt.Errorf("Not enough fields %d Vs. 3", len(items))
-		glog.V(3).Infof(err.Error())
+		glog.V(3).Info(err.Error())
 		return "", err
 	}
 
@@ -433,14 +574,14 @@ func convertSVCUID(uid string) (string, error) {
 	items[2] = strings.TrimSpace(items[2])
 	if items[2] != "svc" {
 		err := fmt.Errorf("%v fields[2] should be [svc]: [%v]", uid, items[2])
-		glog.V(3).Infof(err.Error())
+		glog.V(3).Info(err.Error())
 		return "", err
 	}
 
 	//3. construct the new uid
 	if len(items[0]) < 1 || len(items[1]) < 1 {
 		err := fmt.Errorf("Invalid fields: %v/%v", items[0], items[1])
-		glog.V(3).Infof(err.Error())
+		glog.V(3).Info(err.Error())
 		return "", err
 	}
 
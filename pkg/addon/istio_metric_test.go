@@ -1,6 +1,9 @@
 package addon
 
 import (
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
 	"fmt"
 	pclient "github.com/songbinliu/xfire/pkg/prometheus"
 	"strings"
@@ -142,3 +145,188 @@ func TestParseIP(t *testing.T) {
 		t.Errorf("Wrong result: %v Vs. %v", result, expected)
 	}
 }
+
+func TestIstioEntityGetter_Percentiles(t *testing.T) {
+	du := turboMetricDuration
+	pod := `"destination_uid":"kubernetes://video-671194421-vpxkh.default","destination_ip":"10.2.1.104"`
+	server := newFakePrometheus(map[string]string{
+		getRPSExp(true, du, ""):                     `{"metric":{` + pod + `},"value":[1524246000,"10"]}`,
+		getLatencyExp(true, du, ""):                 `{"metric":{` + pod + `},"value":[1524246000,"2.5"]}`,
+		getLatencyPercentileExp(true, 50, du, ""):   `{"metric":{` + pod + `},"value":[1524246000,"2"]}`,
+		getLatencyPercentileExp(true, 99.9, du, ""): `{"metric":{` + pod + `},"value":[1524246000,"9.5"]}`,
+	})
+	defer server.Close()
+
+	client, err := pclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	g := newIstioEntityGetter("istio.app.metric")
+	g.SetType(false)
+	// query for 90 does not exist, and should not fail the getter
+	g.SetPercentiles([]float64{50, 90, 99.9})
+
+	result, err := g.GetEntityMetric(client)
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result) != 1 {
+		t.Errorf("Expected 1 entity, got %d: %+v", len(result), result)
+		return
+	}
+
+	e := result[0]
+	expects := map[string]float64{
+		inter.TPS:       10,
+		inter.Latency:   2.5,
+		"latency_p50":   2,
+		"latency_p99_9": 9.5,
+	}
+	for k, v := range expects {
+		if e.Metrics[k] != v {
+			t.Errorf("Wrong metric %v: %v Vs. %v", k, e.Metrics[k], v)
+		}
+	}
+
+	if _, exist := e.Metrics["latency_p90"]; exist {
+		t.Errorf("Failed percentile query should be skipped: %+v", e.Metrics)
+	}
+}
+
+func TestGetLatencyPercentileExp(t *testing.T) {
+	exp := getLatencyPercentileExp(true, 99, "3m", "")
+	expect := `histogram_quantile(0.99, sum(rate(istio_turbo_pod_latency_time_ms_bucket{response_code="200"}[3m])) by (le, destination_uid, destination_ip))`
+	if exp != expect {
+		t.Errorf("Wrong expression:\n%v\nVs.\n%v", exp, expect)
+	}
+
+	exp = getLatencyPercentileExp(false, 50, "1m", "")
+	expect = `histogram_quantile(0.5, sum(rate(istio_turbo_service_latency_time_ms_bucket{response_code="200"}[1m])) by (le, destination_uid))`
+	if exp != expect {
+		t.Errorf("Wrong expression:\n%v\nVs.\n%v", exp, expect)
+	}
+}
+
+func TestIstioEntityGetter_ResponseCode(t *testing.T) {
+	du := turboMetricDuration
+	pod1 := `"destination_uid":"kubernetes://video-671194421-vpxkh.default","destination_ip":"10.2.1.104"`
+	pod2 := `"destination_uid":"kubernetes://inception-be-41ldc.default","destination_ip":"10.2.1.105"`
+	server := newFakePrometheus(map[string]string{
+		getRPSExp(true, du, ""):     `{"metric":{` + pod1 + `},"value":[1524246000,"6"]}`,
+		getLatencyExp(true, du, ""): `{"metric":{` + pod1 + `},"value":[1524246000,"2.5"]}`,
+		getResponseCodeRateExp(true, du, ""): `{"metric":{` + pod1 + `,"response_code":"200"},"value":[1524246000,"6"]},
+			{"metric":{` + pod1 + `,"response_code":"201"},"value":[1524246000,"1"]},
+			{"metric":{` + pod1 + `,"response_code":"404"},"value":[1524246000,"2"]},
+			{"metric":{` + pod1 + `,"response_code":"503"},"value":[1524246000,"1"]},
+			{"metric":{` + pod2 + `,"response_code":"500"},"value":[1524246000,"3"]}`,
+	})
+	defer server.Close()
+
+	client, err := pclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	g := newIstioEntityGetter("istio.app.metric")
+	g.SetPercentiles(nil)
+
+	result, err := g.GetEntityMetric(client)
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result) != 2 {
+		t.Errorf("Expected 2 entities, got %d: %+v", len(result), result)
+		return
+	}
+
+	expects := map[string]map[string]float64{
+		"10.2.1.104": {
+			inter.TPS:          6,
+			inter.RequestRate:  10,
+			inter.SuccessRate:  7,
+			inter.Error4xxRate: 2,
+			inter.Error5xxRate: 1,
+			inter.ErrorRatio:   0.3,
+		},
+		"10.2.1.105": {
+			inter.RequestRate:  3,
+			inter.SuccessRate:  0,
+			inter.Error5xxRate: 3,
+			inter.ErrorRatio:   1,
+		},
+	}
+
+	for _, e := range result {
+		for k, v := range expects[e.UID] {
+			if e.Metrics[k] != v {
+				t.Errorf("Wrong metric %v of %v: %v Vs. %v", k, e.UID, e.Metrics[k], v)
+			}
+		}
+	}
+}
+
+func TestGetIstioMatcher(t *testing.T) {
+	tests := []struct {
+		pod     bool
+		uid     string
+		matcher string
+	}{
+		{true, "10.2.1.104", `destination_ip="10.2.1.104"`},
+		{true, "default/video-671194421-vpxkh", ""},
+		{true, "", ""},
+		{false, "default/productpage", `destination_uid=~"productpage\\.default\\.svc(\\..*)?"`},
+		{false, "productpage", ""},
+	}
+
+	for _, test := range tests {
+		if m := getIstioMatcher(test.pod, test.uid); m != test.matcher {
+			t.Errorf("Wrong matcher for %v: %v", test.uid, m)
+		}
+	}
+
+	exp := getRPSExp(false, "3m", tests[3].matcher)
+	if exp != `rate(istio_turbo_service_request_count{response_code="200",`+tests[3].matcher+`}[3m])` {
+		t.Errorf("Query is not narrowed: %v", exp)
+	}
+	exp = getResponseCodeRateExp(true, "3m", tests[0].matcher)
+	if exp != `sum(rate(istio_turbo_pod_request_count{`+tests[0].matcher+`}[3m])) by (destination_uid, destination_ip, response_code)` {
+		t.Errorf("Query is not narrowed: %v", exp)
+	}
+}
+
+func TestIstioEntityGetter_UID(t *testing.T) {
+	du := turboMetricDuration
+	matcher := getIstioMatcher(true, "10.2.1.104")
+	pod := `"destination_uid":"kubernetes://video-671194421-vpxkh.default","destination_ip":"10.2.1.104"`
+	// only the narrowed queries have results
+	server := newFakePrometheus(map[string]string{
+		getRPSExp(true, du, matcher):     `{"metric":{` + pod + `},"value":[1524246000,"10"]}`,
+		getLatencyExp(true, du, matcher): `{"metric":{` + pod + `},"value":[1524246000,"2.5"]}`,
+	})
+	defer server.Close()
+
+	client, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	g := newIstioEntityGetter("istio.app.metric")
+	g.SetPercentiles(nil)
+
+	result, err := g.GetEntityMetricWithOptions(client, &alligator.QueryOptions{UID: "10.2.1.104"})
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+	if len(result) != 1 || result[0].UID != "10.2.1.104" || result[0].Metrics[inter.TPS] != 10 {
+		t.Errorf("Wrong entities: %+v", result)
+	}
+}
@@ -0,0 +1,420 @@
+package addon
+
+import (
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
+	"fmt"
+	"github.com/golang/glog"
+	pclient "github.com/songbinliu/xfire/pkg/prometheus"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the standard metrics of Istio 1.x, no custom Mixer config is needed.
+// NOTE: before Istio 1.5, the duration is "istio_request_duration_seconds".
+const (
+	std_REQUEST_TOTAL    = "istio_requests_total"
+	std_DURATION_SUM     = "istio_request_duration_milliseconds_sum"
+	std_DURATION_COUNT   = "istio_request_duration_milliseconds_count"
+	std_DURATION_BUCKET  = "istio_request_duration_milliseconds_bucket"
+	std_REPORTER_FILTER  = `reporter="destination"`
+	std_SUCCESS_FILTER   = `reporter="destination",response_code="200"`
+	std_POD_GROUP_LABELS = "instance, pod, pod_name, namespace, destination_workload, destination_workload_namespace"
+	std_SVC_GROUP_LABELS = "destination_service_name, destination_service_namespace"
+
+	// label of the pod workload
+	workloadLabel = "workload"
+)
+
+// IstioStdEntityGetter : get Pod and Service entities from the Istio standard metrics.
+// The metrics reported by the destination sidecar are used, so the "instance" label
+// (added by Prometheus when scraping the sidecar) gives the IP of the destination Pod.
+type IstioStdEntityGetter struct {
+	name        string
+	etype       int //Pod(Application), or Service
+	window      string
+	percentiles []float64
+}
+
+// ensure IstioStdEntityGetter implement the requisite interfaces
+var _ alligator.OptionsGetter = &IstioStdEntityGetter{}
+
+func newIstioStdEntityGetter(name string, isVirtualApp bool) *IstioStdEntityGetter {
+	g := &IstioStdEntityGetter{
+		name:        name,
+		etype:       podType,
+		window:      turboMetricDuration,
+		percentiles: DefaultLatencyPercentiles,
+	}
+	if isVirtualApp {
+		g.etype = svcType
+	}
+	return g
+}
+
+func (g *IstioStdEntityGetter) Name() string {
+	return g.name
+}
+
+func (g *IstioStdEntityGetter) Category() string {
+	if g.etype == podType {
+		return IstioStdGetterCategory
+	}
+	return IstioStdVAppGetterCategory
+}
+
+// SetWindow sets the rate window of the queries, such as "3m"
+func (g *IstioStdEntityGetter) SetWindow(window string) {
+	g.window = window
+}
+
+// Window returns the rate window of the queries
+func (g *IstioStdEntityGetter) Window() string {
+	return g.window
+}
+
+// SetPercentiles sets the latency percentiles to compute, such as 50, 90, 99
+func (g *IstioStdEntityGetter) SetPercentiles(percentiles []float64) {
+	g.percentiles = percentiles
+}
+
+func (g *IstioStdEntityGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	return g.GetEntityMetricWithOptions(client, nil)
+}
+
+// GetEntityMetricWithOptions gets the entity metrics, with the rate window overridden by opts.
+// TPS and latency are got by one query, and the percentiles by another; the queries are sent concurrently.
+func (g *IstioStdEntityGetter) GetEntityMetricWithOptions(client promclient.MetricClient, opts *alligator.QueryOptions) ([]*inter.EntityMetric, error) {
+	result := []*inter.EntityMetric{}
+	midresult := make(map[string]*inter.EntityMetric)
+	pod := g.etype == podType
+	window := queryWindow(g.window, opts)
+	matcher := ""
+	if opts != nil {
+		matcher = getStdMatcher(pod, opts.UID)
+	}
+
+	inputs := []pclient.RequestInput{
+		newIstioStdQuery(pod, combineQueries([]namedQuery{
+			{inter.TPS, getStdRPSExp(pod, window, matcher)},
+			{inter.Latency, getStdLatencyExp(pod, window, matcher)},
+		})),
+		newIstioStdQuery(pod, getStdResponseCodeRateExp(pod, window, matcher)),
+	}
+	if len(g.percentiles) > 0 {
+		queries := []namedQuery{}
+		for _, p := range g.percentiles {
+			queries = append(queries, namedQuery{inter.LatencyPercentile(p), getStdLatencyPercentileExp(pod, p, window, matcher)})
+		}
+		inputs = append(inputs, newIstioStdQuery(pod, combineQueries(queries)))
+	}
+	results := getMetrics(client, inputs...)
+
+	//1. TPS and latency are required
+	if err := results[0].err; err != nil {
+		glog.Errorf("Failed to get Istio TPS and Latency metrics: %v", err)
+		return result, err
+	}
+	dat := splitByMetric(results[0].dat)
+	g.addMetrics(midresult, dat[inter.TPS], inter.TPS)
+	g.addMetrics(midresult, dat[inter.Latency], inter.Latency)
+
+	//2. request rate by response code is optional
+	if err := results[1].err; err != nil {
+		glog.Errorf("Failed to get Istio request rate by response code: %v", err)
+	} else {
+		g.addResponseCodeMetrics(midresult, results[1].dat)
+	}
+
+	//3. percentiles are optional
+	if len(results) > 2 {
+		if err := results[2].err; err != nil {
+			glog.Errorf("Failed to get Istio latency percentiles %v: %v", g.percentiles, err)
+		} else {
+			dat := splitByMetric(results[2].dat)
+			for _, p := range g.percentiles {
+				key := inter.LatencyPercentile(p)
+				g.addMetrics(midresult, dat[key], key)
+			}
+		}
+	}
+
+	for _, entity := range midresult {
+		result = append(result, entity)
+	}
+	return result, nil
+}
+
+// addMetrics sets the metric of the entities, creating the entities not found in midresult
+func (g *IstioStdEntityGetter) addMetrics(midresult map[string]*inter.EntityMetric, mdat []pclient.MetricData, key string) {
+	etype := inter.ApplicationType
+	if g.etype == svcType {
+		etype = inter.VirtualApplicationType
+	}
+
+	for _, dat := range mdat {
+		metric, ok := dat.(*istioStdMetricData)
+		if !ok {
+			glog.Errorf("Type assertion failed for %v: not an istioStdMetricData", key)
+			continue
+		}
+
+		entity, exist := midresult[metric.uuid]
+		if !exist {
+			entity = inter.NewEntityMetric(metric.uuid, etype)
+			for k, v := range metric.Labels {
+				entity.SetLabel(k, v)
+			}
+			entity.SetLabel(inter.Category, g.Category())
+			midresult[entity.UID] = entity
+		}
+		entity.SetMetric(key, metric.GetValue())
+	}
+}
+
+// addResponseCodeMetrics sets the total request rate, success (2xx) rate, 4xx rate, 5xx rate,
+// and error ratio ((4xx + 5xx) / total) of the entities.
+func (g *IstioStdEntityGetter) addResponseCodeMetrics(midresult map[string]*inter.EntityMetric, mdat []pclient.MetricData) {
+	rates := make(map[string]map[string]float64)
+	first := make(map[string]*istioStdMetricData)
+
+	for _, dat := range mdat {
+		metric, ok := dat.(*istioStdMetricData)
+		if !ok {
+			glog.Errorf("Type assertion failed for response code: not an istioStdMetricData")
+			continue
+		}
+
+		r, exist := rates[metric.uuid]
+		if !exist {
+			r = map[string]float64{inter.RequestRate: 0, inter.SuccessRate: 0, inter.Error4xxRate: 0, inter.Error5xxRate: 0}
+			rates[metric.uuid] = r
+			first[metric.uuid] = metric
+		}
+
+		v := metric.GetValue()
+		r[inter.RequestRate] += v
+		switch {
+		case strings.HasPrefix(metric.code, "2"):
+			r[inter.SuccessRate] += v
+		case strings.HasPrefix(metric.code, "4"):
+			r[inter.Error4xxRate] += v
+		case strings.HasPrefix(metric.code, "5"):
+			r[inter.Error5xxRate] += v
+		}
+	}
+
+	for uid, r := range rates {
+		if total := r[inter.RequestRate]; total > 0 {
+			r[inter.ErrorRatio] = (r[inter.Error4xxRate] + r[inter.Error5xxRate]) / total
+		}
+
+		for k, v := range r {
+			dat := *first[uid]
+			dat.Value = v
+			g.addMetrics(midresult, []pclient.MetricData{&dat}, k)
+		}
+	}
+}
+
+// istioStdQuery : an immutable query for Pods or Services
+type istioStdQuery struct {
+	query string
+	pod   bool
+}
+
+func newIstioStdQuery(pod bool, query string) *istioStdQuery {
+	return &istioStdQuery{
+		query: query,
+		pod:   pod,
+	}
+}
+
+func (q *istioStdQuery) GetQuery() string {
+	return q.query
+}
+
+func (q *istioStdQuery) Parse(m *pclient.RawMetric) (pclient.MetricData, error) {
+	d := &istioStdMetricData{
+		Labels: make(map[string]string),
+	}
+
+	var err error
+	if q.pod {
+		err = d.parsePod(m)
+	} else {
+		err = d.parseService(m)
+	}
+
+	if err != nil {
+		glog.V(3).Infof("Failed to parse Istio metrics: %v", err)
+		return nil, err
+	}
+	return d, nil
+}
+
+// istioStdMetricData : hold the result of the Istio standard metrics
+type istioStdMetricData struct {
+	Labels map[string]string
+	Value  float64
+	uuid   string
+	code   string //response code, if grouped by it
+	metric string //name of the metric, if from a combined query
+}
+
+func (d *istioStdMetricData) GetValue() float64 {
+	return d.Value
+}
+
+// MetricName returns the name of the metric, if the data is from a combined query
+func (d *istioStdMetricData) MetricName() string {
+	return d.metric
+}
+
+func (d *istioStdMetricData) parseValue(m *pclient.RawMetric) error {
+	d.Value = float64(m.Value.Value)
+	if math.IsNaN(d.Value) {
+		return fmt.Errorf("Failed to convert value: NaN")
+	}
+	d.code = m.Labels["response_code"]
+	d.metric = m.Labels[metricNameLabel]
+	return nil
+}
+
+// parsePod: the UID is the Pod IP (from "instance"); the name is "<namespace>/<podName>"
+func (d *istioStdMetricData) parsePod(m *pclient.RawMetric) error {
+	if err := d.parseValue(m); err != nil {
+		return err
+	}
+	labels := m.Labels
+
+	//1. pod name: "pod" or "pod_name", depending on the Prometheus scrape config
+	podName := firstLabel(labels, "pod", "pod_name")
+	namespace := firstLabel(labels, "namespace", "destination_workload_namespace")
+	if len(podName) > 0 && len(namespace) > 0 {
+		d.Labels[inter.Name] = fmt.Sprintf("%s/%s", namespace, podName)
+	}
+	if w := labels["destination_workload"]; len(w) > 0 && w != "unknown" {
+		d.Labels[workloadLabel] = w
+	}
+
+	//2. ip
+	ip := ""
+	if instance := strings.TrimSpace(labels["instance"]); len(instance) > 0 {
+		ip = instance
+		if host, _, err := net.SplitHostPort(instance); err == nil {
+			ip = host
+		}
+	}
+	if len(ip) > 0 {
+		d.Labels[inter.IP] = ip
+		d.uuid = ip
+		return nil
+	}
+
+	if name, ok := d.Labels[inter.Name]; ok {
+		glog.V(3).Infof("No instance label, use name as uid: %v", name)
+		d.uuid = name
+		return nil
+	}
+
+	return fmt.Errorf("No instance or pod label: %v", labels)
+}
+
+// parseService: the UID and name are "<namespace>/<serviceName>"
+func (d *istioStdMetricData) parseService(m *pclient.RawMetric) error {
+	if err := d.parseValue(m); err != nil {
+		return err
+	}
+	labels := m.Labels
+
+	name := strings.TrimSpace(labels["destination_service_name"])
+	namespace := strings.TrimSpace(labels["destination_service_namespace"])
+	if len(name) < 1 || name == "unknown" || len(namespace) < 1 || namespace == "unknown" {
+		return fmt.Errorf("Invalid service: %v/%v", namespace, name)
+	}
+
+	d.uuid = fmt.Sprintf("%s/%s", namespace, name)
+	d.Labels[inter.Name] = d.uuid
+	return nil
+}
+
+// firstLabel returns the value of the first label found
+func firstLabel(labels map[string]string, names ...string) string {
+	for _, name := range names {
+		if v := strings.TrimSpace(labels[name]); len(v) > 0 && v != "unknown" {
+			return v
+		}
+	}
+	return ""
+}
+
+func getStdGroupLabels(pod bool) string {
+	if pod {
+		return std_POD_GROUP_LABELS
+	}
+	return std_SVC_GROUP_LABELS
+}
+
+// getStdMatcher returns the label matchers narrowing the queries to the entity of uid;
+// empty if the uid cannot be mapped to labels.
+// Pod: instance=~"10\\.2\\.1\\.104:.*"; Service: destination_service_namespace="default",destination_service_name="productpage"
+func getStdMatcher(pod bool, uid string) string {
+	if len(uid) < 1 {
+		return ""
+	}
+
+	if pod {
+		if net.ParseIP(uid) == nil {
+			return ""
+		}
+		return fmt.Sprintf("instance=~%v", strconv.Quote(regexp.QuoteMeta(uid)+":.*"))
+	}
+
+	items := strings.SplitN(uid, "/", 2)
+	if len(items) != 2 || len(items[0]) < 1 || len(items[1]) < 1 {
+		return ""
+	}
+	return fmt.Sprintf("destination_service_namespace=%v,destination_service_name=%v",
+		strconv.Quote(items[0]), strconv.Quote(items[1]))
+}
+
+// withMatcher appends the matchers of the entity to the label filter
+func withMatcher(filter, matcher string) string {
+	if len(matcher) < 1 {
+		return filter
+	}
+	return filter + "," + matcher
+}
+
+// exp = sum(rate(istio_requests_total{reporter="destination",response_code="200"}[3m])) by (...)
+func getStdRPSExp(pod bool, du, matcher string) string {
+	return fmt.Sprintf("sum(rate(%v{%v}[%v])) by (%v)",
+		std_REQUEST_TOTAL, withMatcher(std_SUCCESS_FILTER, matcher), du, getStdGroupLabels(pod))
+}
+
+// exp = sum(rate(duration_sum{...}[3m])) by (...) / sum(rate(duration_count{...}[3m])) by (...)
+func getStdLatencyExp(pod bool, du, matcher string) string {
+	labels := getStdGroupLabels(pod)
+	filter := withMatcher(std_SUCCESS_FILTER, matcher)
+	return fmt.Sprintf("sum(rate(%v{%v}[%v])) by (%v) / sum(rate(%v{%v}[%v])) by (%v)",
+		std_DURATION_SUM, filter, du, labels,
+		std_DURATION_COUNT, filter, du, labels)
+}
+
+// exp = histogram_quantile(0.9, sum(rate(duration_bucket{...}[3m])) by (le, ...))
+func getStdLatencyPercentileExp(pod bool, percentile float64, du, matcher string) string {
+	q := strconv.FormatFloat(percentile/100.0, 'f', -1, 64)
+	return fmt.Sprintf("histogram_quantile(%v, sum(rate(%v{%v}[%v])) by (le, %v))",
+		q, std_DURATION_BUCKET, withMatcher(std_SUCCESS_FILTER, matcher), du, getStdGroupLabels(pod))
+}
+
+// exp = sum(rate(istio_requests_total{reporter="destination"}[3m])) by (..., response_code)
+func getStdResponseCodeRateExp(pod bool, du, matcher string) string {
+	return fmt.Sprintf("sum(rate(%v{%v}[%v])) by (%v, response_code)",
+		std_REQUEST_TOTAL, withMatcher(std_REPORTER_FILTER, matcher), du, getStdGroupLabels(pod))
+}
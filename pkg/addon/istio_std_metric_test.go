@@ -0,0 +1,132 @@
+package addon
+
+import (
+	"strings"
+	"testing"
+
+	"appMetric/pkg/inter"
+	pclient "github.com/songbinliu/xfire/pkg/prometheus"
+)
+
+func TestIstioStdEntityGetter_Pod(t *testing.T) {
+	du := turboMetricDuration
+	pod1 := `"instance":"10.2.1.104:15090","pod":"productpage-v1-7d6b8c8f9-x2x4q","namespace":"default","destination_workload":"productpage-v1","destination_workload_namespace":"default"`
+	pod2 := `"instance":"10.2.1.105:15090","pod_name":"reviews-v1-5f7c8d9f8-abcde","destination_workload":"reviews-v1","destination_workload_namespace":"bookinfo"`
+	server := newFakePrometheus(map[string]string{
+		getStdRPSExp(true, du, ""): `{"metric":{` + pod1 + `},"value":[1524246000,"8"]},
+			{"metric":{` + pod2 + `},"value":[1524246000,"2"]}`,
+		getStdLatencyExp(true, du, ""):               `{"metric":{` + pod1 + `},"value":[1524246000,"12.5"]}`,
+		getStdLatencyPercentileExp(true, 99, du, ""): `{"metric":{` + pod1 + `},"value":[1524246000,"40"]}`,
+		getStdResponseCodeRateExp(true, du, ""): `{"metric":{` + pod1 + `,"response_code":"200"},"value":[1524246000,"8"]},
+			{"metric":{` + pod1 + `,"response_code":"503"},"value":[1524246000,"2"]}`,
+	})
+	defer server.Close()
+
+	client, err := pclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	g := newIstioStdEntityGetter("istio.std.app.metric", false)
+	g.SetPercentiles([]float64{99})
+	result, err := g.GetEntityMetric(client)
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result) != 2 {
+		t.Errorf("Expected 2 entities, got %d: %+v", len(result), result)
+		return
+	}
+
+	for _, e := range result {
+		if e.Type != inter.ApplicationType || e.Labels[inter.Category] != IstioStdGetterCategory {
+			t.Errorf("Wrong entity: %+v", e)
+		}
+
+		switch e.UID {
+		case "10.2.1.104":
+			if e.Labels[inter.Name] != "default/productpage-v1-7d6b8c8f9-x2x4q" || e.Labels[workloadLabel] != "productpage-v1" {
+				t.Errorf("Wrong labels: %+v", e.Labels)
+			}
+			if e.Metrics[inter.TPS] != 8 || e.Metrics[inter.Latency] != 12.5 || e.Metrics["latency_p99"] != 40 {
+				t.Errorf("Wrong metrics: %+v", e.Metrics)
+			}
+			if e.Metrics[inter.RequestRate] != 10 || e.Metrics[inter.Error5xxRate] != 2 || e.Metrics[inter.ErrorRatio] != 0.2 {
+				t.Errorf("Wrong response code metrics: %+v", e.Metrics)
+			}
+		case "10.2.1.105":
+			if e.Labels[inter.Name] != "bookinfo/reviews-v1-5f7c8d9f8-abcde" || e.Labels[inter.IP] != "10.2.1.105" {
+				t.Errorf("Wrong labels: %+v", e.Labels)
+			}
+		default:
+			t.Errorf("Unexpected entity: %+v", e)
+		}
+	}
+}
+
+func TestIstioStdEntityGetter_Service(t *testing.T) {
+	du := turboMetricDuration
+	svc := `"destination_service_name":"productpage","destination_service_namespace":"default"`
+	server := newFakePrometheus(map[string]string{
+		getStdRPSExp(false, du, ""): `{"metric":{` + svc + `},"value":[1524246000,"8"]},
+			{"metric":{"destination_service_name":"unknown","destination_service_namespace":"unknown"},"value":[1524246000,"1"]}`,
+		getStdLatencyExp(false, du, ""): `{"metric":{` + svc + `},"value":[1524246000,"12.5"]}`,
+	})
+	defer server.Close()
+
+	client, err := pclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	g := newIstioStdEntityGetter("istio.std.vapp.metric", true)
+	g.SetPercentiles(nil)
+	result, err := g.GetEntityMetric(client)
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result) != 1 {
+		t.Errorf("Expected 1 entity, got %d: %+v", len(result), result)
+		return
+	}
+
+	e := result[0]
+	if e.UID != "default/productpage" || e.Type != inter.VirtualApplicationType || e.Labels[inter.Category] != IstioStdVAppGetterCategory {
+		t.Errorf("Wrong entity: %+v", e)
+	}
+
+	if e.Metrics[inter.TPS] != 8 || e.Metrics[inter.Latency] != 12.5 {
+		t.Errorf("Wrong metrics: %+v", e.Metrics)
+	}
+}
+
+func TestGetStdMatcher(t *testing.T) {
+	tests := []struct {
+		pod     bool
+		uid     string
+		matcher string
+	}{
+		{true, "10.2.1.104", `instance=~"10\\.2\\.1\\.104:.*"`},
+		{true, "default/productpage-v1", ""},
+		{true, "", ""},
+		{false, "default/productpage", `destination_service_namespace="default",destination_service_name="productpage"`},
+		{false, "productpage", ""},
+	}
+
+	for _, test := range tests {
+		if m := getStdMatcher(test.pod, test.uid); m != test.matcher {
+			t.Errorf("Wrong matcher for %v: %v", test.uid, m)
+		}
+	}
+
+	exp := getStdRPSExp(false, "3m", tests[3].matcher)
+	if !strings.Contains(exp, "{"+std_SUCCESS_FILTER+","+tests[3].matcher+`}`) {
+		t.Errorf("Query is not narrowed: %v", exp)
+	}
+}
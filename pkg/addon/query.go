@@ -0,0 +1,80 @@
+package addon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"appMetric/pkg/promclient"
+	pclient "github.com/songbinliu/xfire/pkg/prometheus"
+)
+
+// the label naming the metric of each series in a combined query
+const metricNameLabel = "appmetric_metric"
+
+// namedQuery : one metric of a combined query
+type namedQuery struct {
+	name  string
+	query string
+}
+
+// combineQueries combines the queries into one round trip; the series of each query are labeled with its name:
+// label_replace(<tps>, "appmetric_metric", "tps", "", "") or label_replace(<latency>, "appmetric_metric", "latency", "", "")
+// The series of different queries never collide in "or", as their names differ.
+func combineQueries(queries []namedQuery) string {
+	parts := make([]string, 0, len(queries))
+	for _, q := range queries {
+		parts = append(parts, fmt.Sprintf(`label_replace(%v, "%v", %v, "", "")`, q.query, metricNameLabel, strconv.Quote(q.name)))
+	}
+	return strings.Join(parts, " or ")
+}
+
+// namedMetricData : the data parsed from a combined query, knowing the name of its metric
+type namedMetricData interface {
+	MetricName() string
+}
+
+// metricName returns the name of the metric of the data from a combined query
+func metricName(dat pclient.MetricData) string {
+	switch d := dat.(type) {
+	case namedMetricData:
+		return d.MetricName()
+	case *pclient.BasicMetricData:
+		return d.Labels[metricNameLabel]
+	}
+	return ""
+}
+
+// splitByMetric groups the data of a combined query by the name of the metric
+func splitByMetric(mdat []pclient.MetricData) map[string][]pclient.MetricData {
+	result := make(map[string][]pclient.MetricData)
+	for _, dat := range mdat {
+		name := metricName(dat)
+		result[name] = append(result[name], dat)
+	}
+	return result
+}
+
+// queryResult : the result of one query sent by getMetrics
+type queryResult struct {
+	dat []pclient.MetricData
+	err error
+}
+
+// getMetrics sends the queries concurrently, and returns their results in the same order
+func getMetrics(client promclient.MetricClient, inputs ...pclient.RequestInput) []queryResult {
+	result := make([]queryResult, len(inputs))
+
+	var wg sync.WaitGroup
+	for i := range inputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result[i].dat, result[i].err = client.GetMetrics(inputs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return result
+}
@@ -0,0 +1,144 @@
+package addon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
+)
+
+func TestCombineQueries(t *testing.T) {
+	exp := combineQueries([]namedQuery{
+		{inter.TPS, `rate(redis_commands_processed_total[3m])`},
+		{inter.Latency, `max(redis_memory_max_bytes or redis_config_maxmemory) by (addr)`},
+	})
+	expect := `label_replace(rate(redis_commands_processed_total[3m]), "appmetric_metric", "tps", "", "")` +
+		` or label_replace(max(redis_memory_max_bytes or redis_config_maxmemory) by (addr), "appmetric_metric", "latency", "", "")`
+	if exp != expect {
+		t.Errorf("Wrong expression:\n%v\nVs.\n%v", exp, expect)
+	}
+}
+
+func TestIstioEntityGetter_RoundTrips(t *testing.T) {
+	du := turboMetricDuration
+	pod := `"destination_uid":"kubernetes://video-671194421-vpxkh.default","destination_ip":"10.2.1.104"`
+	server := newFakePrometheus(map[string]string{
+		getRPSExp(true, du, ""):                   `{"metric":{` + pod + `},"value":[1524246000,"10"]}`,
+		getLatencyExp(true, du, ""):               `{"metric":{` + pod + `},"value":[1524246000,"2.5"]}`,
+		getLatencyPercentileExp(true, 50, du, ""): `{"metric":{` + pod + `},"value":[1524246000,"2"]}`,
+		getLatencyPercentileExp(true, 90, du, ""): `{"metric":{` + pod + `},"value":[1524246000,"4"]}`,
+		getResponseCodeRateExp(true, du, ""):      `{"metric":{` + pod + `,"response_code":"200"},"value":[1524246000,"10"]}`,
+	})
+	defer server.Close()
+
+	var requests int32
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, r)
+	})
+
+	client, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	g := newIstioEntityGetter("istio.app.metric")
+	g.SetPercentiles([]float64{50, 90})
+	result, err := g.GetEntityMetric(client)
+	if err != nil || len(result) != 1 {
+		t.Errorf("Failed to get entity metrics: %v, %+v", err, result)
+		return
+	}
+
+	// TPS with latency, the percentiles, and the response codes
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("Expected 3 queries, got %d", n)
+	}
+
+	expects := map[string]float64{
+		inter.TPS:         10,
+		inter.Latency:     2.5,
+		"latency_p50":     2,
+		"latency_p90":     4,
+		inter.RequestRate: 10,
+	}
+	for k, v := range expects {
+		if result[0].Metrics[k] != v {
+			t.Errorf("Wrong metric %v: %v Vs. %v", k, result[0].Metrics[k], v)
+		}
+	}
+}
+
+// Scrapes run in parallel, as the HTTP requests do; run with -race to catch the shared state of the getters.
+// Each entity should get its own TPS and latency, not the ones parsed by the query of another scrape.
+func TestGetters_ParallelScrapes(t *testing.T) {
+	du := turboMetricDuration
+	pod := `"destination_uid":"kubernetes://video-671194421-vpxkh.default","destination_ip":"10.2.1.104"`
+	stdPod := `"instance":"10.2.1.105:15090","pod":"productpage-v1-7d6b8c8f9-x2x4q","namespace":"default"`
+	q := newRedisQuery(du)
+	server := newFakePrometheus(map[string]string{
+		getRPSExp(true, du, ""):                               `{"metric":{` + pod + `},"value":[1524246000,"10"]}`,
+		getLatencyExp(true, du, ""):                           `{"metric":{` + pod + `},"value":[1524246000,"2.5"]}`,
+		getStdRPSExp(true, du, ""):                            `{"metric":{` + stdPod + `},"value":[1524246000,"8"]}`,
+		getStdLatencyExp(true, du, ""):                        `{"metric":{` + stdPod + `},"value":[1524246000,"12.5"]}`,
+		q.getRPSExp():                                         `{"metric":{"addr":"10.2.2.65:6379"},"value":[1524246000,"1.5"]}`,
+		q.getLatencyExp():                                     `{"metric":{"addr":"10.2.2.65:6379"},"value":[1524246000,"0.025"]}`,
+		fmt.Sprintf("rate(memcached_commands_total[%v])", du): `{"metric":{"instance":"10.0.2.3:11211"},"value":[1524246000,"12"]}`,
+		"memcached_latency_ms":                                `{"metric":{"instance":"10.0.2.3:11211"},"value":[1524246000,"1.5"]}`,
+	})
+	defer server.Close()
+
+	client, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	generic, err := NewGenericEntityGetter(newGenericConf())
+	if err != nil {
+		t.Errorf("Failed to create generic getter: %v", err)
+		return
+	}
+	app := alligator.NewAlligator(client)
+	app.AddGetter(newIstioEntityGetter("istio.app.metric"))
+	app.AddGetter(newIstioStdEntityGetter("istio.std.app.metric", false))
+	app.AddGetter(NewRedisEntityGetter("redis.app.metric"))
+	app.AddGetter(generic)
+
+	expects := map[string][2]float64{
+		"10.2.1.104": {10, 2.5},
+		"10.2.1.105": {8, 12.5},
+		"10.2.2.65":  {1.5, 0.025},
+		"10.0.2.3":   {12, 1.5},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				result, err := app.GetEntityMetrics(context.Background())
+				if err != nil || len(result.Entities) != len(expects) {
+					t.Errorf("Wrong scrape: %v, %+v", err, result)
+					return
+				}
+				for _, e := range result.Entities {
+					expect := expects[e.UID]
+					if e.Metrics[inter.TPS] != expect[0] || e.Metrics[inter.Latency] != expect[1] {
+						t.Errorf("Wrong metrics of %v: %v", e.UID, e.Metrics)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
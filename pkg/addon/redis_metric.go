@@ -3,33 +3,172 @@ package addon
 import (
 	"appMetric/pkg/alligator"
 	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
 	"fmt"
 	"github.com/golang/glog"
 	xfire "github.com/songbinliu/xfire/pkg/prometheus"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 const (
 	redis_OPS_TOTAL = "redis_commands_processed_total"
+	// per command statistics; not available in old versions of redis_exporter
+	redis_CMD_DURATION_TOTAL = "redis_commands_duration_seconds_total"
+	redis_CMD_TOTAL          = "redis_commands_total"
+	// cache health
+	redis_KEYSPACE_HITS   = "redis_keyspace_hits_total"
+	redis_KEYSPACE_MISSES = "redis_keyspace_misses_total"
+	redis_MEMORY_USED     = "redis_memory_used_bytes"
+	redis_MEMORY_MAX      = "redis_memory_max_bytes"
+	// maxmemory in old versions of redis_exporter
+	redis_CONFIG_MAXMEMORY = "redis_config_maxmemory"
+	redis_CLIENTS          = "redis_connected_clients"
+	redis_EVICTED_KEYS     = "redis_evicted_keys_total"
 	// ops_per_sec is too sensitive, so commands_processed_total will be used.
 	//redis_OPS_PER_SEC = "redis_instantaneous_ops_per_sec"
 
 	default_Redis_Port = 6379
 )
 
+// The identity of Redis instances, which decides the UID of the entities
+const (
+	// UID is the IP; instances on the same IP are combined into one entity
+	RedisIdentityIP = "ip"
+	// UID is "ip:port" for every instance
+	RedisIdentityIPPort = "ip:port"
+	// UID is the IP for the instance on the default port 6379, and "ip:port" for the others;
+	// so the UID of an instance does not change when other instances come and go on its IP
+	RedisIdentityAuto = "auto"
+)
+
+// The optional metric groups of Redis getters
+const (
+	// hit_ratio: keyspace hits / (hits + misses)
+	RedisGroupCache = "cache"
+	// memory_used_bytes, memory_max_bytes and memory_usage_ratio; the last two only if maxmemory is set
+	RedisGroupMemory = "memory"
+	// connected_clients
+	RedisGroupClients = "clients"
+	// evicted_keys_rate
+	RedisGroupEvictions = "evictions"
+	// command_rate_<cmd> of the top-N commands of each instance
+	RedisGroupCommands = "commands"
+
+	DefaultRedisTopCommands = 5
+)
+
+// RedisMetricGroups : all the optional metric groups of Redis getters, enabled by default
+var RedisMetricGroups = []string{RedisGroupCache, RedisGroupMemory, RedisGroupClients, RedisGroupEvictions, RedisGroupCommands}
+
+// The metric names of the optional groups
+const (
+	RedisHitRatio         = "hit_ratio"
+	RedisMemoryUsed       = "memory_used_bytes"
+	RedisMemoryMax        = "memory_max_bytes"
+	RedisMemoryRatio      = "memory_usage_ratio"
+	RedisConnectedClients = "connected_clients"
+	RedisEvictedRate      = "evicted_keys_rate"
+	// followed by the command name, such as "command_rate_get"
+	RedisCommandRatePrefix = "command_rate_"
+)
+
+// ValidateRedisMetricGroups checks that each group is one of RedisMetricGroups
+func ValidateRedisMetricGroups(groups []string) error {
+	for _, g := range groups {
+		valid := false
+		for _, name := range RedisMetricGroups {
+			if g == name {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid redis metric group: [%v], should be one of %v", g, RedisMetricGroups)
+		}
+	}
+	return nil
+}
+
+// ValidateRedisIdentity checks whether identity is one of "ip", "ip:port" and "auto"
+func ValidateRedisIdentity(identity string) error {
+	switch identity {
+	case RedisIdentityIP, RedisIdentityIPPort, RedisIdentityAuto:
+		return nil
+	}
+	return fmt.Errorf("invalid redis identity: [%v], should be one of %v, %v, %v",
+		identity, RedisIdentityIP, RedisIdentityIPPort, RedisIdentityAuto)
+}
+
 type RedisEntityGetter struct {
-	name  string
-	query *redisQuery
+	name     string
+	window   string
+	identity string
+
+	// the enabled optional metric groups
+	groups      map[string]bool
+	topCommands int
 }
 
 // ensure RedisEntityGetter implement the requisite interfaces
-var _ alligator.EntityMetricGetter = &RedisEntityGetter{}
+var _ alligator.OptionsGetter = &RedisEntityGetter{}
 
 func NewRedisEntityGetter(name string) *RedisEntityGetter {
 	return &RedisEntityGetter{
-		name:  name,
-		query: newRedisQuery(),
+		name:     name,
+		window:   turboMetricDuration,
+		identity: RedisIdentityAuto,
+
+		groups:      newRedisGroupSet(RedisMetricGroups),
+		topCommands: DefaultRedisTopCommands,
+	}
+}
+
+func newRedisGroupSet(groups []string) map[string]bool {
+	result := make(map[string]bool)
+	for _, g := range groups {
+		result[g] = true
+	}
+	return result
+}
+
+// SetMetricGroups enables the optional metric groups, and disables the others; nil or empty disables all of them.
+func (r *RedisEntityGetter) SetMetricGroups(groups []string) error {
+	if err := ValidateRedisMetricGroups(groups); err != nil {
+		return err
+	}
+	r.groups = newRedisGroupSet(groups)
+	return nil
+}
+
+// SetTopCommands sets the number of commands reported by the "commands" group for each instance
+func (r *RedisEntityGetter) SetTopCommands(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid top commands: %d, should be positive", n)
+	}
+	r.topCommands = n
+	return nil
+}
+
+// SetIdentity sets how the instances are identified: RedisIdentityIP, RedisIdentityIPPort or RedisIdentityAuto
+func (r *RedisEntityGetter) SetIdentity(identity string) error {
+	if err := ValidateRedisIdentity(identity); err != nil {
+		return err
 	}
+	r.identity = identity
+	return nil
+}
+
+// SetWindow sets the rate window of the queries, such as "3m"
+func (r *RedisEntityGetter) SetWindow(window string) {
+	r.window = window
+}
+
+// Window returns the rate window of the queries
+func (r *RedisEntityGetter) Window() string {
+	return r.window
 }
 
 func (r *RedisEntityGetter) Name() string {
@@ -40,40 +179,89 @@ func (r *RedisEntityGetter) Category() string {
 	return "Redis"
 }
 
-func (r *RedisEntityGetter) GetEntityMetric(client *xfire.RestClient) ([]*inter.EntityMetric, error) {
+func (r *RedisEntityGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	return r.GetEntityMetricWithOptions(client, nil)
+}
+
+// GetEntityMetricWithOptions gets the entity metrics, with the rate window overridden by opts.
+// TPS and latency are got by one query, and the optional metric groups by another; the queries are sent concurrently.
+func (r *RedisEntityGetter) GetEntityMetricWithOptions(client promclient.MetricClient, opts *alligator.QueryOptions) ([]*inter.EntityMetric, error) {
 	result := []*inter.EntityMetric{}
-	midResult := make(map[string]*inter.EntityMetric)
+	midResult := make(map[string]*redisInstance)
 
-	//1. get TPS data
-	r.query.SetQueryType(false)
-	tpsDat, err := client.GetMetrics(r.query)
-	if err != nil {
-		glog.Errorf("Failed to get Redis TPS metrics: %v", err)
-		return result, err
-	} else {
-		r.addEntity(tpsDat, midResult, inter.TPS)
+	window, matcher := queryWindow(r.window, opts), ""
+	if opts != nil {
+		matcher = getRedisMatcher(opts.UID)
 	}
+	query := newRedisEntityQuery(window, matcher)
 
-	//2. get Latency data
-	r.query.SetQueryType(true)
-	latencyDat, err := client.GetMetrics(r.query)
-	if err != nil {
-		glog.Errorf("Failed to get Redis Latency metrics: %v", err)
-		//return result, err
-	} else {
-		r.addEntity(latencyDat, midResult, inter.Latency)
+	groups := r.groupQueries(query)
+	inputs := []xfire.RequestInput{newRedisInput(query.getEntityExp())}
+	if len(groups) > 0 {
+		inputs = append(inputs, newRedisInput(combineQueries(groups)))
 	}
+	results := getMetrics(client, inputs...)
 
-	//3. reform map to list
-	for _, v := range midResult {
-		result = append(result, v)
+	//1. get TPS and Latency data; Latency is not available on old exporters
+	if err := results[0].err; err != nil {
+		glog.Errorf("Failed to get Redis TPS and Latency metrics: %v", err)
+		return result, err
+	}
+	dat := splitByMetric(results[0].dat)
+	r.addEntity(dat[inter.TPS], midResult, inter.TPS)
+	r.addEntity(dat[inter.Latency], midResult, inter.Latency)
+
+	//2. get the optional metric groups; the entities are kept if they fail
+	if len(results) > 1 {
+		if err := results[1].err; err != nil {
+			glog.Errorf("Failed to get Redis metric groups: %v", err)
+		} else {
+			dat := splitByMetric(results[1].dat)
+			for _, q := range groups {
+				r.addEntity(dat[q.name], midResult, q.name)
+			}
+		}
 	}
 
+	//3. identify the instances
+	result = r.buildEntities(midResult)
 	return result, nil
 }
 
-// key should be inter.TPS or inter.Latency
-func (r *RedisEntityGetter) addEntity(mdat []xfire.MetricData, result map[string]*inter.EntityMetric, key string) error {
+// redisInstance : the metrics of one Redis instance, identified by ip:port
+type redisInstance struct {
+	ip      string
+	port    string
+	metrics map[string]float64
+}
+
+// groupQueries returns the query of each metric in the enabled optional groups, named by the metric
+func (r *RedisEntityGetter) groupQueries(q *redisQuery) []namedQuery {
+	result := []namedQuery{}
+
+	if r.groups[RedisGroupCache] {
+		result = append(result, namedQuery{RedisHitRatio, q.getHitRatioExp()})
+	}
+	if r.groups[RedisGroupMemory] {
+		result = append(result, namedQuery{RedisMemoryUsed, q.getGaugeExp(redis_MEMORY_USED)})
+		result = append(result, namedQuery{RedisMemoryMax, q.getMemoryMaxExp()})
+	}
+	if r.groups[RedisGroupClients] {
+		result = append(result, namedQuery{RedisConnectedClients, q.getGaugeExp(redis_CLIENTS)})
+	}
+	if r.groups[RedisGroupEvictions] {
+		result = append(result, namedQuery{RedisEvictedRate, q.getEvictedRateExp()})
+	}
+	if r.groups[RedisGroupCommands] {
+		result = append(result, namedQuery{RedisCommandRatePrefix, q.getTopCommandsExp(r.topCommands)})
+	}
+
+	return result
+}
+
+// key should be inter.TPS, inter.Latency, or a metric of the optional groups;
+// for RedisCommandRatePrefix, the key is followed by the "cmd" label.
+func (r *RedisEntityGetter) addEntity(mdat []xfire.MetricData, result map[string]*redisInstance, key string) error {
 	addrName := "addr"
 
 	for _, dat := range mdat {
@@ -96,80 +284,294 @@ func (r *RedisEntityGetter) addEntity(mdat []xfire.MetricData, result map[string
 			continue
 		}
 
-		//2. add entity metrics
-		entity, ok := result[ip]
+		mkey := key
+		if key == RedisCommandRatePrefix {
+			cmd, ok := metric.Labels["cmd"]
+			if !ok || len(cmd) < 1 {
+				glog.Errorf("Label cmd is not found")
+				continue
+			}
+			mkey = key + cmd
+		}
+
+		// maxmemory is 0 if it is not set
+		if key == RedisMemoryMax && metric.GetValue() <= 0 {
+			continue
+		}
+
+		//2. add instance metrics
+		addr = net.JoinHostPort(ip, port)
+		instance, ok := result[addr]
 		if !ok {
-			entity = inter.NewEntityMetric(ip, inter.ApplicationType)
-			entity.SetLabel(inter.IP, ip)
-			entity.SetLabel(inter.Port, port)
-			entity.SetLabel(inter.Category, r.Category())
-			result[ip] = entity
+			instance = &redisInstance{ip: ip, port: port, metrics: make(map[string]float64)}
+			result[addr] = instance
 		}
 
-		entity.SetMetric(key, metric.GetValue())
+		instance.metrics[mkey] = metric.GetValue()
 	}
 
 	return nil
 }
 
+// buildEntities generates the entities from the instances, according to the identity
+func (r *RedisEntityGetter) buildEntities(instances map[string]*redisInstance) []*inter.EntityMetric {
+	result := []*inter.EntityMetric{}
+
+	//1. one entity for each instance
+	if r.identity != RedisIdentityIP {
+		for _, instance := range instances {
+			entity := r.newEntity(r.instanceUID(instance), instance.ip, instance.port)
+			setRedisMetrics(entity, instance.metrics)
+			result = append(result, entity)
+		}
+		return result
+	}
+
+	//2. one entity for each IP
+	groups := make(map[string][]*redisInstance)
+	for _, instance := range instances {
+		groups[instance.ip] = append(groups[instance.ip], instance)
+	}
+	for ip, group := range groups {
+		// the port label is only for a single instance, as a combined entity has no port
+		port := ""
+		if len(group) > 1 {
+			glog.V(2).Infof("Combine %d Redis instances on %v into one entity", len(group), ip)
+		} else {
+			port = group[0].port
+		}
+		entity := r.newEntity(ip, ip, port)
+		setRedisMetrics(entity, combineRedisMetrics(group))
+		result = append(result, entity)
+	}
+
+	return result
+}
+
+// instanceUID returns the UID of one instance: "ip:port", or the IP for the default port in auto identity
+func (r *RedisEntityGetter) instanceUID(instance *redisInstance) string {
+	if r.identity == RedisIdentityAuto && instance.port == strconv.Itoa(default_Redis_Port) {
+		return instance.ip
+	}
+	return net.JoinHostPort(instance.ip, instance.port)
+}
+
+func (r *RedisEntityGetter) newEntity(uid, ip, port string) *inter.EntityMetric {
+	entity := inter.NewEntityMetric(uid, inter.ApplicationType)
+	entity.SetLabel(inter.IP, ip)
+	if len(port) > 0 {
+		entity.SetLabel(inter.Port, port)
+	}
+	entity.SetLabel(inter.Category, r.Category())
+	return entity
+}
+
+// setRedisMetrics sets the metrics of the entity, and the memory usage ratio derived from them
+func setRedisMetrics(entity *inter.EntityMetric, metrics map[string]float64) {
+	for k, v := range metrics {
+		entity.SetMetric(k, v)
+	}
+
+	used, ok1 := metrics[RedisMemoryUsed]
+	max, ok2 := metrics[RedisMemoryMax]
+	if ok1 && ok2 && max > 0 {
+		entity.SetMetric(RedisMemoryRatio, used/max)
+	}
+}
+
+// combineRedisMetrics combines the metrics of the instances on the same IP:
+// Latency is averaged weighted by TPS, hit ratio is averaged, and the others are summed up.
+func combineRedisMetrics(group []*redisInstance) map[string]float64 {
+	result := make(map[string]float64)
+	if len(group) == 1 {
+		return group[0].metrics
+	}
+
+	weighted, weight := 0.0, 0.0
+	hasLatency := false
+	ratios := []float64{}
+	for _, instance := range group {
+		for k, v := range instance.metrics {
+			switch k {
+			case inter.Latency:
+			case RedisHitRatio:
+				ratios = append(ratios, v)
+			default:
+				result[k] += v
+			}
+		}
+
+		t := instance.metrics[inter.TPS]
+		if latency, ok := instance.metrics[inter.Latency]; ok && t > 0 {
+			weighted += latency * t
+			weight += t
+			hasLatency = true
+		}
+	}
+
+	if hasLatency {
+		result[inter.Latency] = weighted / weight
+	}
+	if len(ratios) > 0 {
+		sum := 0.0
+		for _, v := range ratios {
+			sum += v
+		}
+		result[RedisHitRatio] = sum / float64(len(ratios))
+	}
+	return result
+}
+
+// parseIP returns the host and port of the addr label of redis_exporter, such as "10.2.2.65:6379",
+// "redis://10.2.2.65:6379" or "[fd00::1]:6379"; the port is the default one if it is missing.
 func (r *RedisEntityGetter) parseIP(addr string) (string, string, error) {
+	//1. strip the scheme, the user info and the path of redis URLs
 	addr = strings.TrimSpace(addr)
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+len("://"):]
+	}
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr = addr[:i]
+	}
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		addr = addr[i+1:]
+	}
 	if len(addr) < 2 {
 		return "", "", fmt.Errorf("Illegal addr[%v]", addr)
 	}
 
-	items := strings.Split(addr, ":")
-	if len(items) >= 2 {
-		return items[0], items[1], nil
+	//2. split host and port; addr without port is the host, such as "10.2.2.65" or "fd00::1"
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		port = ""
+	}
+	if len(host) < 1 {
+		return "", "", fmt.Errorf("Illegal addr[%v]: no host", addr)
 	}
-	return items[0], fmt.Sprintf("%v", default_Redis_Port), nil
+	if len(port) < 1 {
+		port = fmt.Sprintf("%v", default_Redis_Port)
+	}
+	return host, port, nil
 }
 
 //------------------ Get and Parse the metrics ---------------
-// QueryTypes
-//    0: TPS
-//    1: Latency
+// redisQuery : the immutable builder of the queries with the rate window, narrowed by the label matcher
 type redisQuery struct {
-	qtype    int
-	queryMap map[int]string
+	window string
+
+	// the label matchers narrowing the queries to some instances; empty for all the instances
+	matcher string
 }
 
-func newRedisQuery() *redisQuery {
-	q := &redisQuery{
-		qtype:    0,
-		queryMap: make(map[int]string),
+func newRedisQuery(window string) *redisQuery {
+	return newRedisEntityQuery(window, "")
+}
+
+// newRedisEntityQuery creates the queries narrowed by the label matcher, such as addr=~"10\\.2\\.2\\.65:.*"
+func newRedisEntityQuery(window, matcher string) *redisQuery {
+	return &redisQuery{
+		window:  window,
+		matcher: matcher,
 	}
+}
 
-	q.queryMap[0] = q.getRPSExp()
-	q.queryMap[1] = q.getLatencyExp()
-	return q
+// getRedisMatcher returns the matcher of the instances on the host of uid, which is "ip" or "ip:port";
+// all the instances on the host are needed to combine them in ip identity.
+// The addr label may be a redis URL, or an IPv6 address in brackets, as parseIP accepts.
+// Empty if the uid is not of a Redis instance.
+func getRedisMatcher(uid string) string {
+	ip := uid
+	if host, _, err := net.SplitHostPort(uid); err == nil {
+		ip = host
+	}
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return fmt.Sprintf("addr=~%v", strconv.Quote("(.*://)?(.*@)?\\[?"+regexp.QuoteMeta(ip)+"\\]?([:/].*)?"))
 }
 
-func (q *redisQuery) SetQueryType(isLatency bool) {
-	if isLatency {
-		q.qtype = 1
-	} else {
-		q.qtype = 0
+// selector returns the metric name with the matcher, such as redis_connected_clients{addr=~"..."}
+func (q *redisQuery) selector(name string) string {
+	if len(q.matcher) < 1 {
+		return name
 	}
+	return fmt.Sprintf("%v{%v}", name, q.matcher)
 }
 
-func (q *redisQuery) GetQuery() string {
-	return q.queryMap[q.qtype]
+// TPS and Latency in one query
+func (q *redisQuery) getEntityExp() string {
+	return combineQueries([]namedQuery{
+		{inter.TPS, q.getRPSExp()},
+		{inter.Latency, q.getLatencyExp()},
+	})
 }
 
 // rate(redis_commands_processed_total[3m])
 func (q *redisQuery) getRPSExp() string {
-	result := fmt.Sprintf("rate(%v[%v])", redis_OPS_TOTAL, turboMetricDuration)
+	result := fmt.Sprintf("rate(%v[%v])", q.selector(redis_OPS_TOTAL), q.window)
 	glog.V(3).Infof("Redis TPS: %v", result)
 	return result
 }
 
+// average command latency in ms, same unit as Istio:
+// 1000 * sum(rate(redis_commands_duration_seconds_total[3m])) by (addr) / (sum(rate(redis_commands_total[3m])) by (addr) > 0)
+// On old exporters without these series, the result is empty, and no entity gets latency.
+// Idle instances are filtered out by "> 0", instead of getting NaN.
 func (q *redisQuery) getLatencyExp() string {
-	glog.Errorf("Redis has no Latency metric.")
-	return ""
+	result := fmt.Sprintf("1000 * sum(rate(%v[%v])) by (addr) / (sum(rate(%v[%v])) by (addr) > 0)",
+		q.selector(redis_CMD_DURATION_TOTAL), q.window, q.selector(redis_CMD_TOTAL), q.window)
+	glog.V(3).Infof("Redis Latency: %v", result)
+	return result
+}
+
+// hit ratio of the keyspace; instances without any lookup are filtered out:
+// sum(rate(redis_keyspace_hits_total[3m])) by (addr) / (sum(rate(redis_keyspace_hits_total[3m])) by (addr) + sum(rate(redis_keyspace_misses_total[3m])) by (addr) > 0)
+func (q *redisQuery) getHitRatioExp() string {
+	hits := fmt.Sprintf("sum(rate(%v[%v])) by (addr)", q.selector(redis_KEYSPACE_HITS), q.window)
+	misses := fmt.Sprintf("sum(rate(%v[%v])) by (addr)", q.selector(redis_KEYSPACE_MISSES), q.window)
+	result := fmt.Sprintf("%v / (%v + %v > 0)", hits, hits, misses)
+	glog.V(3).Infof("Redis hit ratio: %v", result)
+	return result
+}
+
+// max(redis_connected_clients) by (addr)
+func (q *redisQuery) getGaugeExp(name string) string {
+	return fmt.Sprintf("max(%v) by (addr)", q.selector(name))
+}
+
+// max(redis_memory_max_bytes or redis_config_maxmemory) by (addr)
+func (q *redisQuery) getMemoryMaxExp() string {
+	return fmt.Sprintf("max(%v or %v) by (addr)", q.selector(redis_MEMORY_MAX), q.selector(redis_CONFIG_MAXMEMORY))
+}
+
+// sum(rate(redis_evicted_keys_total[3m])) by (addr)
+func (q *redisQuery) getEvictedRateExp() string {
+	return fmt.Sprintf("sum(rate(%v[%v])) by (addr)", q.selector(redis_EVICTED_KEYS), q.window)
+}
+
+// the top-N commands of each instance:
+// topk(5, sum(rate(redis_commands_total[3m])) by (addr, cmd)) by (addr)
+func (q *redisQuery) getTopCommandsExp(n int) string {
+	result := fmt.Sprintf("topk(%d, sum(rate(%v[%v])) by (addr, cmd)) by (addr)", n, q.selector(redis_CMD_TOTAL), q.window)
+	glog.V(3).Infof("Redis top commands: %v", result)
+	return result
+}
+
+// redisInput : an immutable query, parsed into xfire.BasicMetricData
+type redisInput struct {
+	query string
+}
+
+func newRedisInput(query string) *redisInput {
+	return &redisInput{query: query}
+}
+
+func (q *redisInput) GetQuery() string {
+	return q.query
 }
 
-func (q *redisQuery) Parse(m *xfire.RawMetric) (xfire.MetricData, error) {
+func (q *redisInput) Parse(m *xfire.RawMetric) (xfire.MetricData, error) {
 	d := xfire.NewBasicMetricData()
 	if err := d.Parse(m); err != nil {
 		return nil, err
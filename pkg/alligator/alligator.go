@@ -1,34 +1,152 @@
 package alligator
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/golang/glog"
 
 	"appMetric/pkg/inter"
-	"github.com/songbinliu/xfire/pkg/prometheus"
+	"appMetric/pkg/promclient"
+	"appMetric/pkg/selfmetric"
+)
+
+const (
+	defaultGetterTimeout = 30 * time.Second
 )
 
+// EntityMetricGetter : gets the entities and their metrics from Prometheus.
+// GetEntityMetric is called concurrently by the HTTP requests and the collector,
+// so it should not modify the getter: the queries should be built for each call.
 type EntityMetricGetter interface {
-	GetEntityMetric(client *prometheus.RestClient) ([]*inter.EntityMetric, error)
+	GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error)
 	Name() string
-	//Category() string
+	Category() string
+}
+
+// QueryOptions : the options of one scrape, such as the ones from an HTTP request
+type QueryOptions struct {
+	// the rate window overriding the ones of the getters, such as "1m"; empty to keep the getters' windows
+	Window string
+	// the time the queries are evaluated at; zero for now. Ignored by range queries.
+	Time time.Time
+	// the UID of the only entity wanted; empty for all the entities.
+	// The getters may narrow their queries to it, and the other entities are dropped.
+	UID string
+}
+
+// OptionsGetter : an EntityMetricGetter supporting QueryOptions
+type OptionsGetter interface {
+	EntityMetricGetter
+	GetEntityMetricWithOptions(client promclient.MetricClient, opts *QueryOptions) ([]*inter.EntityMetric, error)
+	// Window returns the rate window used when there is no QueryOptions
+	Window() string
 }
 
 // Alligator: aggregates several kinds of Entity metric getters
 type Alligator struct {
-	pclient *prometheus.RestClient
+	// such as "app"; labels the operational metrics of the Alligator
+	name string
+
+	// protects the getter set, which can be replaced by Reload
+	lock sync.RWMutex
+
+	pclient *promclient.RestClient
 	Getters map[string]EntityMetricGetter
+
+	// names of the getters, in the order they are added
+	names []string
+
+	// the deadline for each getter; getters exceeding it are dropped
+	timeout time.Duration
+
+	// how to merge the entities sharing the same UID
+	merger *entityMerger
+
+	// the outcome of the recent scrapes, kept by Reload
+	health healthTracker
+}
+
+// the getter set used by one call of GetEntityMetrics
+type scrapeState struct {
+	// the name of the Alligator, labeling the operational metrics
+	name    string
+	pclient *promclient.RestClient
+	getters []EntityMetricGetter
+	timeout time.Duration
+	merger  *entityMerger
+}
+
+// ScrapeResult : the entity metrics, and the outcome of each getter
+type ScrapeResult struct {
+	Entities []*inter.EntityMetric
+	Getters  []*inter.GetterStatus
+
+	// the entities of each getter before merging, kept for GetEntityMetricsWithFallback
+	getterEntities map[string][]*inter.EntityMetric
 }
 
-func NewAlligator(pclient *prometheus.RestClient) *Alligator {
+// RangeResult : the entity metrics over a time range, and the outcome of each getter
+type RangeResult struct {
+	Range   *promclient.Range
+	Series  []*inter.EntitySeries
+	Getters []*inter.GetterStatus
+}
+
+// result of one getter: the entities of each step of the scrape
+type getterResult struct {
+	index  int
+	steps  [][]*inter.EntityMetric
+	status *inter.GetterStatus
+}
+
+func newScrapeResult() *ScrapeResult {
+	return &ScrapeResult{
+		Entities: []*inter.EntityMetric{},
+		Getters:  []*inter.GetterStatus{},
+	}
+}
+
+// FailedNum returns the number of failed getters
+func (r *ScrapeResult) FailedNum() int {
+	return failedNum(r.Getters)
+}
+
+// FailedNum returns the number of failed getters
+func (r *RangeResult) FailedNum() int {
+	return failedNum(r.Getters)
+}
+
+func failedNum(getters []*inter.GetterStatus) int {
+	num := 0
+	for _, g := range getters {
+		if !g.Success {
+			num++
+		}
+	}
+	return num
+}
+
+func NewAlligator(pclient *promclient.RestClient) *Alligator {
 	result := &Alligator{
 		pclient: pclient,
 		Getters: make(map[string]EntityMetricGetter),
+		names:   []string{},
+		timeout: defaultGetterTimeout,
+		merger:  newEntityMerger(MergePreferFirst, nil),
 	}
 
 	return result
 }
 
 func (c *Alligator) AddGetter(getter EntityMetricGetter) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	name := getter.Name()
 	if _, exist := c.Getters[name]; exist {
 		glog.Errorf("Entity Metric Getter: %v already exists", name)
@@ -36,20 +154,481 @@ func (c *Alligator) AddGetter(getter EntityMetricGetter) bool {
 	}
 
 	c.Getters[name] = getter
+	c.names = append(c.names, name)
 	return true
 }
 
-func (c *Alligator) GetEntityMetrics() ([]*inter.EntityMetric, error) {
+// SetMergePolicy sets how to merge the entities sharing the same UID from different getters;
+// categories are in priority order, and only used by MergePreferCategory.
+func (c *Alligator) SetMergePolicy(policy MergePolicy, categories []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.merger = newEntityMerger(policy, categories)
+}
+
+// SetName sets the name labeling the operational metrics of the Alligator, such as "app"
+func (c *Alligator) SetName(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.name = name
+}
+
+func (c *Alligator) Name() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.name
+}
+
+// SetGetterTimeout sets the deadline for each getter; non-positive value means no deadline.
+func (c *Alligator) SetGetterTimeout(timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.timeout = timeout
+}
+
+// Reload replaces the getter set (including the Prometheus client, timeout and merge policy)
+// with the one of src atomically. The calls of GetEntityMetrics already running
+// will finish against the old getter set.
+func (c *Alligator) Reload(src *Alligator) {
+	src.lock.RLock()
+	defer src.lock.RUnlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pclient = src.pclient
+	c.Getters = make(map[string]EntityMetricGetter)
+	for k, v := range src.Getters {
+		c.Getters[k] = v
+	}
+	c.names = append([]string{}, src.names...)
+	c.timeout = src.timeout
+	c.merger = src.merger
+}
+
+// GetterNames returns the names of the getters, in the order they are added
+func (c *Alligator) GetterNames() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]string{}, c.names...)
+}
+
+func (c *Alligator) getState() *scrapeState {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	state := &scrapeState{
+		name:    c.name,
+		pclient: c.pclient,
+		getters: []EntityMetricGetter{},
+		timeout: c.timeout,
+		merger:  c.merger,
+	}
+	for _, name := range c.names {
+		state.getters = append(state.getters, c.Getters[name])
+	}
+	return state
+}
+
+// GetEntityMetrics runs all the getters concurrently, and aggregates their results.
+// Getters that fail or exceed the deadline are dropped from the entities,
+// and their failures are reported in the getter status.
+// Entities sharing the same UID are merged according to the merge policy.
+// An error is returned if all the getters failed, or if ctx is cancelled.
+func (c *Alligator) GetEntityMetrics(ctx context.Context) (*ScrapeResult, error) {
+	return c.GetEntityMetricsWithOptions(ctx, nil)
+}
+
+// GetEntityMetricsWithOptions is GetEntityMetrics with the options of the queries, such as the rate window;
+// the getters not supporting QueryOptions ignore them.
+func (c *Alligator) GetEntityMetricsWithOptions(ctx context.Context, opts *QueryOptions) (*ScrapeResult, error) {
+	return c.getEntityMetrics(ctx, opts, nil)
+}
+
+// GetEntityMetricsWithFallback is GetEntityMetrics, except that each failed getter supplies its entities
+// of last, the result of the previous scrape; so a partial failure does not drop the entities of the failed getters.
+// The status of such a getter is still failed, and marked stale. Nothing is kept if all the getters failed.
+func (c *Alligator) GetEntityMetricsWithFallback(ctx context.Context, last *ScrapeResult) (*ScrapeResult, error) {
+	if last == nil {
+		last = newScrapeResult()
+	}
+	return c.getEntityMetrics(ctx, nil, last)
+}
+
+// getEntityMetrics scrapes with the options; the failed getters fall back to last if it is not nil
+func (c *Alligator) getEntityMetrics(ctx context.Context, opts *QueryOptions, last *ScrapeResult) (*ScrapeResult, error) {
+	result := newScrapeResult()
+	state := c.getState()
+
+	bind := func(ctx context.Context) []promclient.MetricClient {
+		var client promclient.MetricClient = state.pclient
+		if state.pclient != nil {
+			pclient := state.pclient.WithContext(ctx)
+			client = pclient
+			if opts != nil && !opts.Time.IsZero() {
+				client = pclient.At(opts.Time)
+			}
+		}
+		return []promclient.MetricClient{client}
+	}
+
+	getters, succeeded, err := state.scrape(ctx, bind, opts)
+	c.recordHealth(ctx, err)
+	result.Getters = getters
+	if last != nil && err == nil {
+		result.getterEntities = copyGetterEntities(succeeded)
+		succeeded = append(succeeded, state.fallback(getters, last, result.getterEntities)...)
+	}
+	result.Entities = state.mergeEntities(succeeded, 0)
+	if opts != nil && len(opts.UID) > 0 {
+		result.Entities = selectEntity(result.Entities, opts.UID)
+	} else if err == nil && (opts == nil || opts.Time.IsZero()) {
+		c.recordEntityCounts(result.Entities)
+	}
+	return result, err
+}
+
+// GetEntityMetric gets the metrics of the entity with the uid; nil if it is not found
+func (c *Alligator) GetEntityMetric(ctx context.Context, uid string, opts *QueryOptions) (*inter.EntityMetric, *ScrapeResult, error) {
+	o := QueryOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.UID = uid
+
+	result, err := c.GetEntityMetricsWithOptions(ctx, &o)
+	if len(result.Entities) < 1 {
+		return nil, result, err
+	}
+	return result.Entities[0], result, err
+}
+
+// selectEntity returns the entities with the uid
+func selectEntity(entities []*inter.EntityMetric, uid string) []*inter.EntityMetric {
 	result := []*inter.EntityMetric{}
-	for _, getter := range c.Getters {
-		dat, err := getter.GetEntityMetric(c.pclient)
-		if err != nil {
-			glog.Errorf("Failed to get entity metrics: %v", err)
+	for _, e := range entities {
+		if e.UID == uid {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// GetEntityMetricsAt gets the entity metrics as they were at time t
+func (c *Alligator) GetEntityMetricsAt(ctx context.Context, t time.Time) (*ScrapeResult, error) {
+	return c.GetEntityMetricsWithOptions(ctx, &QueryOptions{Time: t})
+}
+
+// GetEntityMetricsRange gets the entity metrics at each timestamp of the range.
+// Each query of the getters is sent once as a range query, and the getters build
+// the entities of each timestamp from its samples, as they do for instant queries.
+// Getter failures and errors are handled as GetEntityMetrics.
+func (c *Alligator) GetEntityMetricsRange(ctx context.Context, r *promclient.Range, opts *QueryOptions) (*RangeResult, error) {
+	result := &RangeResult{
+		Range:   r,
+		Series:  []*inter.EntitySeries{},
+		Getters: []*inter.GetterStatus{},
+	}
+	state := c.getState()
+
+	//1. one step for each timestamp; each getter has its own range client, bound to its deadline
+	timestamps := r.Timestamps()
+	bind := func(ctx context.Context) []promclient.MetricClient {
+		pclient := state.pclient
+		if pclient != nil {
+			pclient = pclient.WithContext(ctx)
+		}
+		rclient := promclient.NewRangeClient(pclient, r)
+		clients := []promclient.MetricClient{}
+		for _, t := range timestamps {
+			clients = append(clients, rclient.At(t))
+		}
+		return clients
+	}
+
+	getters, succeeded, err := state.scrape(ctx, bind, opts)
+	c.recordHealth(ctx, err)
+	result.Getters = getters
+
+	//2. merge the entities of each timestamp, and put them into series
+	series := make(map[string]*inter.EntitySeries)
+	for i, t := range timestamps {
+		for _, e := range state.mergeEntities(succeeded, i) {
+			s, exist := series[e.UID]
+			if !exist {
+				s = inter.NewEntitySeries(e.UID, e.Type)
+				series[e.UID] = s
+				result.Series = append(result.Series, s)
+			}
+
+			for k, v := range e.Labels {
+				s.SetLabel(k, v)
+			}
+			for k, v := range e.Metrics {
+				s.AddSample(k, t.Unix(), v)
+			}
+		}
+	}
+
+	sort.Slice(result.Series, func(i, j int) bool {
+		return result.Series[i].UID < result.Series[j].UID
+	})
+	return result, err
+}
+
+// recordHealth records the outcome of a scrape; the scrapes cancelled by the caller, such as a closed request, tell nothing.
+// A scrape exceeding the deadline of the caller is recorded as failed.
+func (c *Alligator) recordHealth(ctx context.Context, err error) {
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	c.health.record(err, time.Now())
+}
+
+// recordEntityCounts sets the numbers of the entities of a full scrape by category;
+// a merged entity counts in each of its categories.
+func (c *Alligator) recordEntityCounts(entities []*inter.EntityMetric) {
+	counts := make(map[string]float64)
+	for _, e := range entities {
+		categories := []string{e.Labels[inter.Category]}
+		if v, exist := e.Labels[inter.Categories]; exist {
+			categories = strings.Split(v, ",")
+		}
+		for _, category := range categories {
+			counts[category]++
+		}
+	}
+	selfmetric.Entities.Replace(c.Name(), counts)
+}
+
+// Health returns the outcome of the recent scrapes
+func (c *Alligator) Health() ScrapeHealth {
+	return c.health.get()
+}
+
+// Probe checks whether Prometheus is reachable by a cheap query of the jobs, waiting for at most timeout.
+// The outcome shows in the probe fields of Health; it is not a scrape, so LastSuccess is not changed.
+func (c *Alligator) Probe(ctx context.Context, timeout time.Duration) error {
+	state := c.getState()
+	if state.pclient == nil {
+		return fmt.Errorf("no Prometheus client")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := state.pclient.WithContext(ctx).GetJobs()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("Prometheus is not reachable in %v: %v", timeout, err)
+	}
+
+	// the timeout of the probe tells that Prometheus is unreachable, but a cancel of the caller tells nothing
+	if ctx.Err() != context.Canceled {
+		c.health.recordProbe(err, time.Now())
+	}
+	return err
+}
+
+// scrape runs all the getters concurrently; bind returns the clients of one getter, bound to the context of the getter.
+// Each of the clients is one step of the scrape: the Prometheus client for instant queries, or one timestamp of a range.
+// It returns the status of all the getters, and the results of the succeeded ones.
+func (c *scrapeState) scrape(ctx context.Context, bind func(context.Context) []promclient.MetricClient, opts *QueryOptions) ([]*inter.GetterStatus, []*getterResult, error) {
+	statuses := []*inter.GetterStatus{}
+
+	results := make(chan *getterResult, len(c.getters))
+	for i, getter := range c.getters {
+		go func(index int, getter EntityMetricGetter) {
+			res := c.runGetter(ctx, getter, bind, opts)
+			res.index = index
+			results <- res
+		}(i, getter)
+	}
+
+	succeeded := []*getterResult{}
+	var err error
+	for i := 0; i < len(c.getters) && err == nil; i++ {
+		select {
+		case <-ctx.Done():
+			glog.Errorf("Stop waiting for entity getters: %v", ctx.Err())
+			err = ctx.Err()
+		case res := <-results:
+			statuses = append(statuses, res.status)
+			if !res.status.Success {
+				glog.Errorf("Failed to get entity metrics from %v: %v", res.status.Name, res.status.Error)
+				continue
+			}
+			succeeded = append(succeeded, res)
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	if err != nil {
+		return statuses, succeeded, err
+	}
+
+	if num := failedNum(statuses); num > 0 && num == len(statuses) {
+		return statuses, succeeded, fmt.Errorf("all %d getters failed", num)
+	}
+
+	return statuses, succeeded, nil
+}
+
+// fallback returns the results of the failed getters from their entities in last, and marks them stale;
+// the entities are put into kept too, so they are still kept if the getters fail again.
+func (c *scrapeState) fallback(statuses []*inter.GetterStatus, last *ScrapeResult, kept map[string][]*inter.EntityMetric) []*getterResult {
+	index := make(map[string]int)
+	for i, getter := range c.getters {
+		index[getter.Name()] = i
+	}
+
+	result := []*getterResult{}
+	for _, status := range statuses {
+		entities, exist := last.getterEntities[status.Name]
+		if status.Success || !exist {
 			continue
 		}
 
-		result = append(result, dat...)
+		glog.V(2).Infof("Keep %d entities of the failed getter %v from the last scrape", len(entities), status.Name)
+		status.Stale = true
+		kept[status.Name] = entities
+		result = append(result, &getterResult{
+			index:  index[status.Name],
+			steps:  [][]*inter.EntityMetric{copyEntities(entities)},
+			status: status,
+		})
+	}
+	return result
+}
+
+// copyGetterEntities copies the entities of the first step of each getter, as they are modified by merging
+func copyGetterEntities(results []*getterResult) map[string][]*inter.EntityMetric {
+	result := make(map[string][]*inter.EntityMetric)
+	for _, res := range results {
+		if len(res.steps) > 0 {
+			result[res.status.Name] = copyEntities(res.steps[0])
+		}
+	}
+	return result
+}
+
+func copyEntities(entities []*inter.EntityMetric) []*inter.EntityMetric {
+	result := make([]*inter.EntityMetric, 0, len(entities))
+	for _, e := range entities {
+		c := inter.NewEntityMetric(e.UID, e.Type)
+		for k, v := range e.Labels {
+			c.SetLabel(k, v)
+		}
+		for k, v := range e.Metrics {
+			c.SetMetric(k, v)
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// mergeEntities merges the entities of one step of the getters, in the order the getters are added.
+func (c *scrapeState) mergeEntities(results []*getterResult, step int) []*inter.EntityMetric {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].index < results[j].index
+	})
+
+	groups := []*entityGroup{}
+	for _, res := range results {
+		groups = append(groups, &entityGroup{
+			category: res.status.Category,
+			entities: res.steps[step],
+		})
+	}
+
+	return c.merger.merge(groups)
+}
+
+// runGetter runs one getter under its own deadline.
+// The clients of the getter are bound to the deadline, so the requests of an expired getter are cancelled;
+// it is abandoned without waiting for it to return, and its result is discarded.
+func (c *scrapeState) runGetter(ctx context.Context, getter EntityMetricGetter, bind func(context.Context) []promclient.MetricClient, opts *QueryOptions) *getterResult {
+	start := time.Now()
+	status := inter.NewGetterStatus(getter.Name(), getter.Category())
+	result := &getterResult{status: status}
+
+	og, supported := getter.(OptionsGetter)
+	if supported {
+		status.Window = og.Window()
+		if opts != nil && len(opts.Window) > 0 {
+			status.Window = opts.Window
+		}
+	} else if opts != nil {
+		glog.V(3).Infof("Getter %v does not support query options, ignore them.", getter.Name())
+	}
+	defer func() {
+		du := time.Since(start)
+		status.Duration = float64(du) / float64(time.Millisecond)
+		selfmetric.GetterDuration.Observe(du.Seconds(), c.name, status.Name, status.Category)
+		if !status.Success {
+			selfmetric.GetterErrors.Inc(c.name, status.Name, status.Category)
+		}
+	}()
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	clients := bind(ctx)
+
+	type output struct {
+		steps [][]*inter.EntityMetric
+		err   error
+	}
+	done := make(chan *output, 1)
+	go func() {
+		out := &output{}
+		for _, client := range clients {
+			// stop the remaining steps of an abandoned getter
+			if ctx.Err() != nil {
+				out.err = ctx.Err()
+				break
+			}
+
+			var dat []*inter.EntityMetric
+			var err error
+			if supported {
+				dat, err = og.GetEntityMetricWithOptions(client, opts)
+			} else {
+				dat, err = getter.GetEntityMetric(client)
+			}
+			if err != nil {
+				out.err = err
+				break
+			}
+			out.steps = append(out.steps, dat)
+		}
+		done <- out
+	}()
+
+	select {
+	case out := <-done:
+		if out.err != nil {
+			status.SetError(out.err)
+			return result
+		}
+		result.steps = out.steps
+		status.EntityCount = countEntities(out.steps)
+	case <-ctx.Done():
+		status.SetError(fmt.Errorf("getter aborted: %v", ctx.Err()))
 	}
 
-	return result, nil
+	return result
+}
+
+// countEntities returns the number of distinct entities of the steps
+func countEntities(steps [][]*inter.EntityMetric) int {
+	uids := make(map[string]bool)
+	for _, entities := range steps {
+		for _, e := range entities {
+			uids[e.UID] = true
+		}
+	}
+	return len(uids)
 }
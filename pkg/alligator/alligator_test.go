@@ -0,0 +1,737 @@
+package alligator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
+	"appMetric/pkg/selfmetric"
+	xfire "github.com/songbinliu/xfire/pkg/prometheus"
+)
+
+type fakeGetter struct {
+	name     string
+	category string
+	delay    time.Duration
+	err      error
+	uids     []string
+	value    float64
+	// the name of the metric; inter.TPS if empty
+	metric string
+}
+
+func (g *fakeGetter) Name() string {
+	return g.name
+}
+
+func (g *fakeGetter) Category() string {
+	if g.category == "" {
+		return "Fake"
+	}
+	return g.category
+}
+
+func (g *fakeGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	time.Sleep(g.delay)
+	result := []*inter.EntityMetric{}
+	if g.err != nil {
+		return result, g.err
+	}
+
+	for _, uid := range g.uids {
+		e := inter.NewEntityMetric(uid, inter.ApplicationType)
+		e.SetLabel(inter.Category, g.Category())
+		e.SetLabel(g.Category(), "true")
+		metric := g.metric
+		if metric == "" {
+			metric = inter.TPS
+		}
+		e.SetMetric(metric, g.value)
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// fakeOptionsGetter reports the window it used as the metric "window"
+type fakeOptionsGetter struct {
+	fakeGetter
+	window string
+}
+
+func (g *fakeOptionsGetter) Window() string {
+	return g.window
+}
+
+func (g *fakeOptionsGetter) GetEntityMetricWithOptions(client promclient.MetricClient, opts *QueryOptions) ([]*inter.EntityMetric, error) {
+	window := g.window
+	if opts != nil && len(opts.Window) > 0 {
+		window = opts.Window
+	}
+
+	result, err := g.GetEntityMetric(client)
+	for _, e := range result {
+		e.SetLabel("window", window)
+	}
+	return result, err
+}
+
+func TestAlligator_GetEntityMetrics(t *testing.T) {
+	c := NewAlligator(nil)
+	c.AddGetter(&fakeGetter{name: "g1", uids: []string{"a", "b"}})
+	c.AddGetter(&fakeGetter{name: "g2", uids: []string{"c"}})
+	c.AddGetter(&fakeGetter{name: "g3", err: fmt.Errorf("query failed")})
+
+	result, err := c.GetEntityMetrics(context.Background())
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result.Entities) != 3 {
+		t.Errorf("Expected 3 entities, got %d: %+v", len(result.Entities), result.Entities)
+	}
+
+	if len(result.Getters) != 3 || result.FailedNum() != 1 {
+		t.Errorf("Expected 3 getters with 1 failure, got %+v", result.Getters)
+		return
+	}
+
+	for _, g := range result.Getters {
+		switch g.Name {
+		case "g1":
+			if !g.Success || g.EntityCount != 2 || g.Category != "Fake" {
+				t.Errorf("Wrong status for g1: %+v", g)
+			}
+		case "g3":
+			if g.Success || g.Error != "query failed" {
+				t.Errorf("Wrong status for g3: %+v", g)
+			}
+		}
+	}
+}
+
+func TestAlligator_AllFailed(t *testing.T) {
+	c := NewAlligator(nil)
+	c.AddGetter(&fakeGetter{name: "g1", err: fmt.Errorf("query failed")})
+	c.AddGetter(&fakeGetter{name: "g2", err: fmt.Errorf("query failed")})
+
+	result, err := c.GetEntityMetrics(context.Background())
+	if err == nil {
+		t.Errorf("Expected error when all getters failed.")
+	}
+
+	if len(result.Getters) != 2 || result.FailedNum() != 2 {
+		t.Errorf("Expected 2 failed getters, got %+v", result.Getters)
+	}
+}
+
+func TestAlligator_GetterMetrics(t *testing.T) {
+	c := NewAlligator(nil)
+	c.SetName("test-getter-metrics")
+	c.AddGetter(&fakeGetter{name: "g1", uids: []string{"a"}})
+	c.AddGetter(&fakeGetter{name: "g2", err: fmt.Errorf("query failed")})
+	c.GetEntityMetrics(context.Background())
+
+	// the getters are labeled by the Alligator
+	if v := selfmetric.GetterErrors.Get("test-getter-metrics", "g2", "Fake"); v != 1 {
+		t.Errorf("Expected 1 error of g2, got %v", v)
+	}
+	if v := selfmetric.GetterErrors.Get("test-getter-metrics", "g1", "Fake"); v != 0 {
+		t.Errorf("Expected no error of g1, got %v", v)
+	}
+
+	found := false
+	for _, sample := range selfmetric.GetterDuration.Family().Samples {
+		for _, label := range sample.Labels {
+			if label.Name == "alligator" && label.Value == "test-getter-metrics" {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Errorf("No duration of the getters of the Alligator")
+	}
+}
+
+func TestAlligator_Health(t *testing.T) {
+	c := NewAlligator(nil)
+	g := &fakeGetter{name: "g1", err: fmt.Errorf("query failed")}
+	c.AddGetter(g)
+
+	if h := c.Health(); !h.LastScrape.IsZero() {
+		t.Errorf("Expected no scrape yet: %+v", h)
+	}
+
+	//1. failed before the first success
+	c.GetEntityMetrics(context.Background())
+	h := c.Health()
+	if !h.LastSuccess.IsZero() || h.FailingSince.IsZero() || h.LastError == "" {
+		t.Errorf("Expected failing health: %+v", h)
+	}
+	since := h.FailingSince
+
+	//2. still failing since the first failure
+	c.GetEntityMetrics(context.Background())
+	if h := c.Health(); !h.FailingSince.Equal(since) {
+		t.Errorf("Expected failing since %v: %+v", since, h)
+	}
+
+	//3. recovered
+	g.err = nil
+	c.GetEntityMetrics(context.Background())
+	if h := c.Health(); h.LastSuccess.IsZero() || !h.FailingSince.IsZero() {
+		t.Errorf("Expected healthy: %+v", h)
+	}
+
+	//4. the scrapes cancelled by the caller are not recorded
+	g.err = fmt.Errorf("query failed")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.GetEntityMetrics(ctx)
+	if h := c.Health(); !h.FailingSince.IsZero() {
+		t.Errorf("Cancelled scrape is recorded: %+v", h)
+	}
+}
+
+func TestAlligator_Probe(t *testing.T) {
+	var delay time.Duration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		fmt.Fprint(w, `{"status":"success","data":["prometheus"]}`)
+	}))
+	defer server.Close()
+
+	pclient, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+	c := NewAlligator(pclient)
+	c.AddGetter(&fakeGetter{name: "g1"})
+
+	//1. reachable, without running the getters
+	if err := c.Probe(context.Background(), time.Second); err != nil {
+		t.Errorf("Failed to probe: %v", err)
+	}
+	if h := c.Health(); h.LastProbe.IsZero() || !h.ProbeFailingSince.IsZero() {
+		t.Errorf("Expected reachable: %+v", h)
+	}
+	if h := c.Health(); !h.LastSuccess.IsZero() || !h.LastScrape.IsZero() {
+		t.Errorf("Probe is recorded as a scrape: %+v", h)
+	}
+
+	//2. unreachable in the timeout
+	delay = 200 * time.Millisecond
+	if err := c.Probe(context.Background(), 20*time.Millisecond); err == nil {
+		t.Errorf("Expected timeout of probe")
+	}
+	if h := c.Health(); h.ProbeFailingSince.IsZero() || h.ProbeError == "" || !h.FailingSince.IsZero() {
+		t.Errorf("Expected failing probe: %+v", h)
+	}
+}
+
+func TestAlligator_GetterTimeout(t *testing.T) {
+	c := NewAlligator(nil)
+	c.SetGetterTimeout(50 * time.Millisecond)
+	c.AddGetter(&fakeGetter{name: "slow", delay: 2 * time.Second, uids: []string{"a"}})
+	c.AddGetter(&fakeGetter{name: "fast", delay: 10 * time.Millisecond, uids: []string{"b"}})
+
+	start := time.Now()
+	result, err := c.GetEntityMetrics(context.Background())
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if du := time.Since(start); du > time.Second {
+		t.Errorf("Slow getter is not dropped in time: %v", du)
+	}
+
+	if len(result.Entities) != 1 || result.Entities[0].UID != "b" {
+		t.Errorf("Expected only entity[b], got %+v", result.Entities)
+	}
+
+	if result.FailedNum() != 1 {
+		t.Errorf("Expected the slow getter to be reported as failed: %+v", result.Getters)
+	}
+}
+
+// repeatGetter sends the query of queryGetter for times
+type repeatGetter struct {
+	queryGetter
+	times int
+}
+
+func (g *repeatGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	for i := 0; i < g.times; i++ {
+		if _, err := g.queryGetter.GetEntityMetric(client); err != nil {
+			return nil, err
+		}
+	}
+	return []*inter.EntityMetric{}, nil
+}
+
+func TestAlligator_GetterTimeoutCancelRequests(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer server.Close()
+
+	client, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	c := NewAlligator(client)
+	c.SetGetterTimeout(50 * time.Millisecond)
+	c.AddGetter(&repeatGetter{queryGetter: queryGetter{fakeGetter: fakeGetter{name: "slow"}, query: "up"}, times: 50})
+
+	if _, err := c.GetEntityMetrics(context.Background()); err == nil {
+		t.Errorf("Expected the getter to be aborted")
+	}
+
+	// the getter sees the cancelled request, and sends no more
+	time.Sleep(50 * time.Millisecond)
+	sent := atomic.LoadInt32(&count)
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&count); n != sent || n >= 50 {
+		t.Errorf("Requests sent after the getter timeout: %d, then %d", sent, n)
+	}
+}
+
+func TestAlligator_ContextCancel(t *testing.T) {
+	c := NewAlligator(nil)
+	c.SetGetterTimeout(0)
+	c.AddGetter(&fakeGetter{name: "slow", delay: 2 * time.Second, uids: []string{"a"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.GetEntityMetrics(ctx)
+	if err == nil {
+		t.Errorf("Expected error when context is cancelled.")
+	}
+
+	if du := time.Since(start); du > time.Second {
+		t.Errorf("Cancelled context is not honored in time: %v", du)
+	}
+}
+
+func TestCollector_Scrape(t *testing.T) {
+	good := &fakeGetter{name: "g1", uids: []string{"a"}}
+	c := NewAlligator(nil)
+	c.AddGetter(good)
+	collector := NewCollector("test", c, time.Minute)
+
+	if collector.GetSnapshot() != nil {
+		t.Errorf("Snapshot should be nil before the first scrape.")
+	}
+
+	if err := collector.Scrape(context.Background()); err != nil {
+		t.Errorf("Failed to scrape: %v", err)
+		return
+	}
+
+	snapshot := collector.GetSnapshot()
+	if snapshot == nil || len(snapshot.Result.Entities) != 1 {
+		t.Errorf("Wrong snapshot: %+v", snapshot)
+		return
+	}
+
+	//2. the last good snapshot is kept if the scrape failed
+	good.err = fmt.Errorf("query failed")
+	if err := collector.Scrape(context.Background()); err == nil {
+		t.Errorf("Scrape should have failed.")
+	}
+
+	if collector.GetSnapshot() != snapshot {
+		t.Errorf("The last good snapshot is not kept.")
+	}
+}
+
+func TestCollector_PartialFailure(t *testing.T) {
+	g1 := &fakeGetter{name: "g1", uids: []string{"a"}, value: 1}
+	g2 := &fakeGetter{name: "g2", category: "Redis", uids: []string{"a", "b"}, value: 2, metric: inter.Latency}
+	c := NewAlligator(nil)
+	c.AddGetter(g1)
+	c.AddGetter(g2)
+	collector := NewCollector("test", c, time.Minute)
+
+	if err := collector.Scrape(context.Background()); err != nil {
+		t.Errorf("Failed to scrape: %v", err)
+		return
+	}
+
+	//1. the entities of the failed getter are kept from the last snapshot, even after several failures
+	g2.err = fmt.Errorf("query failed")
+	g1.value = 3
+	for i := 0; i < 2; i++ {
+		if err := collector.Scrape(context.Background()); err != nil {
+			t.Errorf("Partial failure should not fail the scrape: %v", err)
+			return
+		}
+
+		result := collector.GetSnapshot().Result
+		entities := make(map[string]*inter.EntityMetric)
+		for _, e := range result.Entities {
+			entities[e.UID] = e
+		}
+		a, b := entities["a"], entities["b"]
+		if len(entities) != 2 || a == nil || b == nil {
+			t.Errorf("Entities of the failed getter are dropped: %+v", result.Entities)
+			return
+		}
+		if a.Metrics[inter.TPS] != 3 || a.Metrics[inter.Latency] != 2 || b.Metrics[inter.Latency] != 2 {
+			t.Errorf("Wrong metrics: %+v, %+v", a.Metrics, b.Metrics)
+		}
+		for _, status := range result.Getters {
+			if status.Name == "g2" && (status.Success || !status.Stale) {
+				t.Errorf("Failed getter should be stale: %+v", status)
+			}
+		}
+	}
+
+	//2. the request-driven scrapes do not fall back
+	result, _ := c.GetEntityMetrics(context.Background())
+	if len(result.Entities) != 1 {
+		t.Errorf("Expected only entity[a], got %+v", result.Entities)
+	}
+}
+
+func getMergedEntity(t *testing.T, c *Alligator, uid string) *inter.EntityMetric {
+	result, err := c.GetEntityMetrics(context.Background())
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return nil
+	}
+
+	if len(result.Entities) != 2 {
+		t.Errorf("Expected 2 entities after merge, got %d: %+v", len(result.Entities), result.Entities)
+		return nil
+	}
+
+	for _, e := range result.Entities {
+		if e.UID == uid {
+			return e
+		}
+	}
+
+	t.Errorf("Entity[%v] is not found: %+v", uid, result.Entities)
+	return nil
+}
+
+func TestAlligator_MergePreferFirst(t *testing.T) {
+	c := NewAlligator(nil)
+	c.AddGetter(&fakeGetter{name: "istio", category: "Istio", uids: []string{"a", "b"}, value: 1})
+	c.AddGetter(&fakeGetter{name: "redis", category: "Redis", uids: []string{"a"}, value: 2})
+
+	e := getMergedEntity(t, c, "a")
+	if e == nil {
+		return
+	}
+
+	if e.Metrics[inter.TPS] != 1 || e.Labels[inter.Category] != "Istio" {
+		t.Errorf("The first getter should win: %+v", e)
+	}
+
+	if e.Labels["Istio"] != "true" || e.Labels["Redis"] != "true" {
+		t.Errorf("Labels are not merged: %+v", e.Labels)
+	}
+
+	if e.Labels[inter.Categories] != "Istio,Redis" {
+		t.Errorf("Wrong contributing categories: %v", e.Labels[inter.Categories])
+	}
+}
+
+func TestAlligator_MergePreferCategory(t *testing.T) {
+	c := NewAlligator(nil)
+	c.SetMergePolicy(MergePreferCategory, []string{"Redis", "Istio"})
+	c.AddGetter(&fakeGetter{name: "istio", category: "Istio", uids: []string{"a", "b"}, value: 1})
+	c.AddGetter(&fakeGetter{name: "redis", category: "Redis", uids: []string{"a"}, value: 2})
+
+	e := getMergedEntity(t, c, "a")
+	if e == nil {
+		return
+	}
+
+	if e.Metrics[inter.TPS] != 2 || e.Labels[inter.Category] != "Redis" {
+		t.Errorf("The Redis getter should win: %+v", e)
+	}
+
+	if e.Labels[inter.Categories] != "Redis,Istio" {
+		t.Errorf("Wrong contributing categories: %v", e.Labels[inter.Categories])
+	}
+}
+
+func TestAlligator_MergeKeepBoth(t *testing.T) {
+	c := NewAlligator(nil)
+	c.SetMergePolicy(MergeKeepBoth, nil)
+	c.AddGetter(&fakeGetter{name: "istio", category: "Istio", uids: []string{"a", "b"}, value: 1})
+	c.AddGetter(&fakeGetter{name: "redis", category: "Redis", uids: []string{"a"}, value: 2})
+
+	e := getMergedEntity(t, c, "a")
+	if e == nil {
+		return
+	}
+
+	if e.Metrics[inter.TPS] != 1 || e.Metrics["Istio.tps"] != 1 || e.Metrics["Redis.tps"] != 2 {
+		t.Errorf("Both metrics should be kept: %+v", e.Metrics)
+	}
+}
+
+// the primary value is prefixed with the category supplying it, not with the first contributor
+func TestAlligator_MergeKeepBothSource(t *testing.T) {
+	c := NewAlligator(nil)
+	c.SetMergePolicy(MergeKeepBoth, nil)
+	c.AddGetter(&fakeGetter{name: "istio", category: "Istio", uids: []string{"a", "b"}, value: 1})
+	c.AddGetter(&fakeGetter{name: "redis", category: "Redis", uids: []string{"a"}, value: 2, metric: inter.Latency})
+	c.AddGetter(&fakeGetter{name: "memcached", category: "Memcached", uids: []string{"a"}, value: 3, metric: inter.Latency})
+
+	e := getMergedEntity(t, c, "a")
+	if e == nil {
+		return
+	}
+
+	if e.Metrics[inter.Latency] != 2 || e.Metrics["Redis.latency"] != 2 || e.Metrics["Memcached.latency"] != 3 {
+		t.Errorf("Wrong latency: %+v", e.Metrics)
+	}
+	if _, exist := e.Metrics["Istio.latency"]; exist {
+		t.Errorf("Latency is not from Istio: %+v", e.Metrics)
+	}
+}
+
+func TestParseMergePolicy(t *testing.T) {
+	for _, s := range []string{"prefer-first", "prefer-category", " keep-both"} {
+		if _, err := ParseMergePolicy(s); err != nil {
+			t.Errorf("Failed to parse merge policy[%v]: %v", s, err)
+		}
+	}
+
+	if _, err := ParseMergePolicy("prefer-last"); err == nil {
+		t.Errorf("Parse merge policy should have failed.")
+	}
+}
+
+func TestAlligator_Reload(t *testing.T) {
+	c := NewAlligator(nil)
+	c.AddGetter(&fakeGetter{name: "slow", delay: 200 * time.Millisecond, uids: []string{"a"}})
+
+	//1. a running call finishes against the old getter set
+	done := make(chan *ScrapeResult, 1)
+	go func() {
+		result, _ := c.GetEntityMetrics(context.Background())
+		done <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	src := NewAlligator(nil)
+	src.AddGetter(&fakeGetter{name: "g1", uids: []string{"b"}})
+	src.AddGetter(&fakeGetter{name: "g2", uids: []string{"c"}})
+	c.Reload(src)
+
+	result := <-done
+	if len(result.Getters) != 1 || result.Getters[0].Name != "slow" {
+		t.Errorf("The running call should use the old getters: %+v", result.Getters)
+	}
+
+	//2. the new calls use the new getter set
+	result, err := c.GetEntityMetrics(context.Background())
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result.Entities) != 2 || len(c.GetterNames()) != 2 {
+		t.Errorf("The new getters are not used: %+v", result.Getters)
+	}
+}
+
+func TestAlligator_GetEntityMetricsWithOptions(t *testing.T) {
+	c := NewAlligator(nil)
+	c.AddGetter(&fakeOptionsGetter{fakeGetter: fakeGetter{name: "g1", uids: []string{"a"}}, window: "3m"})
+	c.AddGetter(&fakeGetter{name: "g2", uids: []string{"b"}})
+
+	for _, test := range []struct {
+		opts   *QueryOptions
+		window string
+	}{
+		{nil, "3m"},
+		{&QueryOptions{}, "3m"},
+		{&QueryOptions{Window: "1m"}, "1m"},
+	} {
+		result, err := c.GetEntityMetricsWithOptions(context.Background(), test.opts)
+		if err != nil || len(result.Entities) != 2 || len(result.Getters) != 2 {
+			t.Errorf("Failed to get entity metrics: %v, %+v", err, result)
+			continue
+		}
+
+		for _, e := range result.Entities {
+			if e.UID == "a" && e.Labels["window"] != test.window {
+				t.Errorf("Expected window %v, got %+v", test.window, e)
+			}
+		}
+
+		// the getter without QueryOptions support has no window in status
+		if result.Getters[0].Window != test.window || result.Getters[1].Window != "" {
+			t.Errorf("Wrong window in getter status: %+v, %+v", result.Getters[0], result.Getters[1])
+		}
+	}
+}
+
+func TestAlligator_GetEntityMetric(t *testing.T) {
+	c := NewAlligator(nil)
+	c.AddGetter(&fakeGetter{name: "g1", uids: []string{"a", "b"}})
+	c.AddGetter(&fakeGetter{name: "g2", category: "Other", uids: []string{"b", "c"}})
+
+	e, result, err := c.GetEntityMetric(context.Background(), "b", nil)
+	if err != nil || e == nil || e.UID != "b" || len(result.Entities) != 1 {
+		t.Errorf("Failed to get entity b: %v, %+v", err, result)
+	}
+
+	e, result, err = c.GetEntityMetric(context.Background(), "d", &QueryOptions{Window: "1m"})
+	if err != nil || e != nil || len(result.Entities) != 0 {
+		t.Errorf("Entity d should not be found: %v, %+v", err, e)
+	}
+}
+
+func TestAlligator_GetEntityMetricsRange(t *testing.T) {
+	c := NewAlligator(nil)
+	c.AddGetter(&fakeGetter{name: "g1", uids: []string{"a", "b"}, value: 1})
+	c.AddGetter(&fakeGetter{name: "g2", category: "Other", uids: []string{"b"}, value: 2})
+	c.AddGetter(&fakeGetter{name: "g3", err: fmt.Errorf("query failed")})
+
+	r, err := promclient.NewRange(time.Unix(1524246000, 0), time.Unix(1524246120, 0), time.Minute)
+	if err != nil {
+		t.Errorf("Failed to create range: %v", err)
+		return
+	}
+
+	result, err := c.GetEntityMetricsRange(context.Background(), r, nil)
+	if err != nil {
+		t.Errorf("Failed to get entity metrics: %v", err)
+		return
+	}
+
+	if len(result.Getters) != 3 || result.FailedNum() != 1 || result.Getters[0].EntityCount != 2 {
+		t.Errorf("Wrong getter status: %+v", result.Getters)
+	}
+
+	if len(result.Series) != 2 || result.Series[0].UID != "a" || result.Series[1].UID != "b" {
+		t.Errorf("Wrong series: %+v", result.Series)
+		return
+	}
+
+	// b is merged: the first getter wins
+	b := result.Series[1]
+	samples := b.Metrics[inter.TPS]
+	if len(samples) != 3 || samples[0].Timestamp != 1524246000 || samples[2].Timestamp != 1524246120 || samples[1].Value != 1 {
+		t.Errorf("Wrong samples: %+v", samples)
+	}
+	if b.Labels[inter.Categories] != "Fake,Other" {
+		t.Errorf("Wrong labels: %+v", b.Labels)
+	}
+}
+
+func TestAlligator_GetEntityMetricsRangeTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(time.Second):
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+	}))
+	defer server.Close()
+
+	client, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	c := NewAlligator(client)
+	c.SetGetterTimeout(50 * time.Millisecond)
+	c.AddGetter(&queryGetter{fakeGetter: fakeGetter{name: "slow"}, query: "up"})
+
+	r, err := promclient.NewRange(time.Unix(1524246000, 0), time.Unix(1524246120, 0), time.Minute)
+	if err != nil {
+		t.Errorf("Failed to create range: %v", err)
+		return
+	}
+	if _, err := c.GetEntityMetricsRange(context.Background(), r, nil); err == nil {
+		t.Errorf("Expected the getter to be aborted")
+	}
+
+	// the range query is cancelled at the getter timeout
+	select {
+	case <-cancelled:
+	case <-time.After(500 * time.Millisecond):
+		t.Errorf("The range query is not cancelled after the getter timeout")
+	}
+}
+
+// queryGetter creates an entity for each sample of its query, with the value as tps
+type queryGetter struct {
+	fakeGetter
+	query string
+}
+
+func (g *queryGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	input := xfire.NewBasicInput()
+	input.SetQuery(g.query)
+	dat, err := client.GetMetrics(input)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*inter.EntityMetric{}
+	for _, d := range dat {
+		e := inter.NewEntityMetric(d.(*xfire.BasicMetricData).Labels["instance"], inter.ApplicationType)
+		e.SetMetric(inter.TPS, d.GetValue())
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func TestAlligator_GetEntityMetricsAt(t *testing.T) {
+	// the value of the sample is the time of the query; 0 for now
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("time")
+		if v == "" {
+			v = "0"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"instance":"a"},"value":[1524246000,"%v"]}]}}`, v)
+	}))
+	defer server.Close()
+
+	client, err := promclient.NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	c := NewAlligator(client)
+	c.AddGetter(&queryGetter{fakeGetter: fakeGetter{name: "g1"}, query: "up"})
+
+	result, err := c.GetEntityMetricsAt(context.Background(), time.Unix(1524246000, 0))
+	if err != nil || len(result.Entities) != 1 || result.Entities[0].Metrics[inter.TPS] != 1524246000 {
+		t.Errorf("Wrong result at 1524246000: %v, %+v", err, result)
+	}
+
+	result, err = c.GetEntityMetrics(context.Background())
+	if err != nil || len(result.Entities) != 1 || result.Entities[0].Metrics[inter.TPS] != 0 {
+		t.Errorf("Wrong result for now: %v, %+v", err, result)
+	}
+}
@@ -0,0 +1,100 @@
+package alligator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Snapshot : the result of a successful scrape, and when it was scraped
+type Snapshot struct {
+	Result    *ScrapeResult
+	Timestamp time.Time
+}
+
+// Age returns how long ago the snapshot was scraped
+func (s *Snapshot) Age() time.Duration {
+	return time.Since(s.Timestamp)
+}
+
+// Collector : refreshes an Alligator periodically, and keeps the last good snapshot.
+// So the load on Prometheus is fixed no matter how many clients are polling.
+type Collector struct {
+	name      string
+	alligator *Alligator
+	interval  time.Duration
+
+	lock     sync.RWMutex
+	snapshot *Snapshot
+}
+
+func NewCollector(name string, alligator *Alligator, interval time.Duration) *Collector {
+	return &Collector{
+		name:      name,
+		alligator: alligator,
+		interval:  interval,
+	}
+}
+
+func (c *Collector) Name() string {
+	return c.name
+}
+
+// GetSnapshot returns the last good snapshot; nil if there is no successful scrape yet.
+func (c *Collector) GetSnapshot() *Snapshot {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.snapshot
+}
+
+// Run scrapes immediately, and then on every interval until stop is closed.
+func (c *Collector) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	glog.V(1).Infof("Collector[%v] begins to scrape every %v", c.name, c.interval)
+	for {
+		c.Scrape(ctx)
+
+		select {
+		case <-stop:
+			glog.V(1).Infof("Collector[%v] stopped.", c.name)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scrape refreshes the Alligator once; the snapshot is kept unless the scrape failed.
+// If some of the getters failed, their entities of the last snapshot are kept in the new one.
+func (c *Collector) Scrape(ctx context.Context) error {
+	now := time.Now()
+	var last *ScrapeResult
+	if snapshot := c.GetSnapshot(); snapshot != nil {
+		last = snapshot.Result
+	}
+	result, err := c.alligator.GetEntityMetricsWithFallback(ctx, last)
+	if err != nil {
+		glog.Errorf("Collector[%v] failed to scrape, keep the last snapshot: %v", c.name, err)
+		return err
+	}
+
+	glog.V(3).Infof("Collector[%v] got %d entities", c.name, len(result.Entities))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.snapshot = &Snapshot{
+		Result:    result,
+		Timestamp: now,
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package alligator
+
+import (
+	"sync"
+	"time"
+)
+
+// ScrapeHealth : the outcome of the recent scrapes of an Alligator, telling whether Prometheus is reachable
+type ScrapeHealth struct {
+	// the time of the last scrape; zero if there is no scrape yet
+	LastScrape time.Time
+	// the time of the last scrape in which not all the getters failed; zero if there is none yet
+	LastSuccess time.Time
+	// the time of the first failed scrape since the last success; zero if the last scrape succeeded
+	FailingSince time.Time
+	// the error of the last failed scrape
+	LastError string
+
+	// the time of the last probe of Prometheus (see Alligator.Probe); zero if there is no probe yet.
+	// The probes only tell whether Prometheus is reachable, and do not count as scrapes.
+	LastProbe time.Time
+	// the time of the first failed probe since the last successful one; zero if the last probe succeeded
+	ProbeFailingSince time.Time
+	// the error of the last failed probe
+	ProbeError string
+}
+
+// healthTracker : records the outcome of the scrapes; the zero value is ready to use
+type healthTracker struct {
+	lock   sync.RWMutex
+	health ScrapeHealth
+}
+
+func (h *healthTracker) record(err error, now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.health.LastScrape = now
+	if err == nil {
+		h.health.LastSuccess = now
+		h.health.FailingSince = time.Time{}
+		return
+	}
+
+	if h.health.FailingSince.IsZero() {
+		h.health.FailingSince = now
+	}
+	h.health.LastError = err.Error()
+}
+
+func (h *healthTracker) recordProbe(err error, now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.health.LastProbe = now
+	if err == nil {
+		h.health.ProbeFailingSince = time.Time{}
+		return
+	}
+
+	if h.health.ProbeFailingSince.IsZero() {
+		h.health.ProbeFailingSince = now
+	}
+	h.health.ProbeError = err.Error()
+}
+
+func (h *healthTracker) get() ScrapeHealth {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.health
+}
@@ -0,0 +1,145 @@
+package alligator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"appMetric/pkg/inter"
+)
+
+// MergePolicy : how to resolve the conflicts when entities from different getters share the same UID
+type MergePolicy string
+
+const (
+	// keep the value from the getter added first
+	MergePreferFirst MergePolicy = "prefer-first"
+	// keep the value from the category ranked first in the category order
+	MergePreferCategory MergePolicy = "prefer-category"
+	// keep the value from the getter added first, and keep all the conflicting
+	// metric values with a category-prefixed name, such as "Redis.tps"
+	MergeKeepBoth MergePolicy = "keep-both"
+)
+
+// ParseMergePolicy converts a string into a MergePolicy
+func ParseMergePolicy(s string) (MergePolicy, error) {
+	p := MergePolicy(strings.TrimSpace(s))
+	switch p {
+	case MergePreferFirst, MergePreferCategory, MergeKeepBoth:
+		return p, nil
+	}
+
+	return p, fmt.Errorf("Unknown merge policy: %v, vs. %v|%v|%v", s, MergePreferFirst, MergePreferCategory, MergeKeepBoth)
+}
+
+// entities from one getter
+type entityGroup struct {
+	category string
+	entities []*inter.EntityMetric
+}
+
+type entityMerger struct {
+	policy MergePolicy
+	// rank of each category, only used by MergePreferCategory
+	rank map[string]int
+}
+
+func newEntityMerger(policy MergePolicy, categories []string) *entityMerger {
+	m := &entityMerger{
+		policy: policy,
+		rank:   make(map[string]int),
+	}
+
+	for i, c := range categories {
+		if _, exist := m.rank[c]; !exist {
+			m.rank[c] = i
+		}
+	}
+	return m
+}
+
+// merge the entities sharing the same UID; groups are in the order of their getters.
+// The merged entity records the contributing categories in label inter.Categories.
+func (m *entityMerger) merge(groups []*entityGroup) []*inter.EntityMetric {
+	if m.policy == MergePreferCategory {
+		sort.SliceStable(groups, func(i, j int) bool {
+			return m.getRank(groups[i].category) < m.getRank(groups[j].category)
+		})
+	}
+
+	result := []*inter.EntityMetric{}
+	merged := make(map[string]*inter.EntityMetric)
+	contributors := make(map[string][]*entityGroup)
+	// the category supplying each metric of the merged entities
+	sources := make(map[string]map[string]string)
+
+	for _, group := range groups {
+		for _, entity := range group.entities {
+			uid := entity.UID
+			primary, exist := merged[uid]
+			if !exist {
+				merged[uid] = entity
+				contributors[uid] = []*entityGroup{group}
+				sources[uid] = make(map[string]string)
+				for k := range entity.Metrics {
+					sources[uid][k] = group.category
+				}
+				result = append(result, entity)
+				continue
+			}
+
+			contributors[uid] = append(contributors[uid], group)
+			m.mergeEntity(primary, sources[uid], entity, group.category)
+		}
+	}
+
+	for _, entity := range result {
+		groups := contributors[entity.UID]
+		if len(groups) < 2 {
+			continue
+		}
+
+		categories := []string{}
+		for _, g := range groups {
+			categories = append(categories, g.category)
+		}
+		entity.SetLabel(inter.Categories, strings.Join(categories, ","))
+	}
+
+	return result
+}
+
+// merge entity into primary: the values of primary win.
+// sources is the category supplying each metric of primary, and is updated with the metrics added from entity.
+func (m *entityMerger) mergeEntity(primary *inter.EntityMetric, sources map[string]string, entity *inter.EntityMetric, category string) {
+	for k, v := range entity.Labels {
+		if _, exist := primary.Labels[k]; !exist {
+			primary.SetLabel(k, v)
+		}
+	}
+
+	for k, v := range entity.Metrics {
+		pv, exist := primary.Metrics[k]
+		if !exist {
+			primary.SetMetric(k, v)
+			sources[k] = category
+			continue
+		}
+
+		if m.policy == MergeKeepBoth {
+			primary.SetMetric(prefixMetricName(sources[k], k), pv)
+			primary.SetMetric(prefixMetricName(category, k), v)
+		}
+	}
+}
+
+func (m *entityMerger) getRank(category string) int {
+	if r, exist := m.rank[category]; exist {
+		return r
+	}
+	return len(m.rank)
+}
+
+func prefixMetricName(category, name string) string {
+	return category + "." + name
+}
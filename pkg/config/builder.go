@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"appMetric/pkg/addon"
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/promclient"
+)
+
+// BuildAlligators creates the Alligators for Applications and VirtualApplications,
+// with their getters and Prometheus clients.
+func (c *Config) BuildAlligators() (*alligator.Alligator, *alligator.Alligator, error) {
+	clients := make(map[string]*promclient.RestClient)
+	factory := addon.NewGetterFactory()
+
+	app, err := c.buildAlligator(c.App, factory, clients)
+	if err != nil {
+		return nil, nil, fmt.Errorf("app: %v", err)
+	}
+	app.SetName("app")
+
+	vapp, err := c.buildAlligator(c.VApp, factory, clients)
+	if err != nil {
+		return nil, nil, fmt.Errorf("vapp: %v", err)
+	}
+	vapp.SetName("vapp")
+
+	return app, vapp, nil
+}
+
+// buildAlligator creates an Alligator; the Prometheus clients are shared by endpoint name.
+func (c *Config) buildAlligator(conf *AlligatorConfig, factory *addon.GetterFactory, clients map[string]*promclient.RestClient) (*alligator.Alligator, error) {
+	pconf, err := c.GetPrometheus(conf.Prometheus)
+	if err != nil {
+		return nil, err
+	}
+
+	pclient, exist := clients[pconf.Name]
+	if !exist {
+		pclient, err = promclient.NewRestClientWithOptions(pconf.URL, pconf.ClientOptions())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for Prometheus %v: %v", pconf.Name, err)
+		}
+		clients[pconf.Name] = pclient
+	}
+
+	result := alligator.NewAlligator(pclient)
+	result.SetGetterTimeout(c.Server.GetterTimeout.Duration)
+	if len(conf.MergePolicy) > 0 {
+		policy, err := alligator.ParseMergePolicy(conf.MergePolicy)
+		if err != nil {
+			return nil, err
+		}
+		result.SetMergePolicy(policy, conf.MergeCategories)
+	}
+
+	for _, gconf := range conf.Getters {
+		getter, err := factory.CreateGetter(gconf)
+		if err != nil {
+			return nil, err
+		}
+		if !result.AddGetter(getter) {
+			return nil, fmt.Errorf("duplicated getter: %v", gconf.Name)
+		}
+	}
+
+	names := []string{}
+	for _, g := range conf.Getters {
+		names = append(names, g.Name)
+	}
+	glog.V(2).Infof("Alligator on Prometheus[%v] with getters: %v", pconf.Name, strings.Join(names, ", "))
+
+	return result, nil
+}
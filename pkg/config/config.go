@@ -0,0 +1,358 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"appMetric/pkg/addon"
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/promclient"
+)
+
+const (
+	defaultPrometheus = "default"
+	defaultPromURL    = "http://localhost:9090"
+	defaultPort       = 8081
+
+	// environment variables overriding the config file
+	EnvPromURL        = "APPMETRIC_PROM_URL"
+	EnvPort           = "APPMETRIC_PORT"
+	EnvScrapeInterval = "APPMETRIC_SCRAPE_INTERVAL"
+	EnvGetterTimeout  = "APPMETRIC_GETTER_TIMEOUT"
+
+	EnvUnreachableTimeout = "APPMETRIC_UNREACHABLE_TIMEOUT"
+)
+
+// Duration : time.Duration in json, such as "30s"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string such as \"30s\": %v", string(b))
+	}
+
+	du, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = du
+	return nil
+}
+
+// Config : the configuration of appMetric
+type Config struct {
+	Server     *ServerConfig       `json:"server"`
+	Prometheus []*PrometheusConfig `json:"prometheus"`
+
+	// getters for Applications, served by /pod/metrics
+	App *AlligatorConfig `json:"app"`
+	// getters for VirtualApplications, served by /service/metrics
+	VApp *AlligatorConfig `json:"vapp"`
+}
+
+type ServerConfig struct {
+	Port int `json:"port"`
+	// if positive, scrape in background and serve the cached snapshot
+	ScrapeInterval Duration `json:"scrapeInterval"`
+	// deadline of each getter
+	GetterTimeout Duration `json:"getterTimeout"`
+	// how long Prometheus can be unreachable before /readyz reports degraded; 0 to disable
+	UnreachableTimeout Duration `json:"unreachableTimeout"`
+}
+
+// PrometheusConfig : a Prometheus endpoint
+type PrometheusConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// TLS: the CA bundle, and the client certificate for mutual TLS
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+
+	// auth: basic auth, or a bearer token file which takes precedence
+	Username        string `json:"username,omitempty"`
+	Password        string `json:"password,omitempty"`
+	BearerTokenFile string `json:"bearerTokenFile,omitempty"`
+
+	// extra headers of each request, such as {"X-Scope-OrgID": "tenant-1"}
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// retries on network errors, 429 and 5xx, with exponential backoff
+	MaxRetries   int      `json:"maxRetries,omitempty"`
+	RetryBackoff Duration `json:"retryBackoff,omitempty"`
+	// timeout of each request; default is 60s
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// ClientOptions returns the options of the Prometheus client of the endpoint
+func (p *PrometheusConfig) ClientOptions() *promclient.ClientOptions {
+	return &promclient.ClientOptions{
+		CAFile:             p.CAFile,
+		CertFile:           p.CertFile,
+		KeyFile:            p.KeyFile,
+		InsecureSkipVerify: p.InsecureSkipVerify,
+		Username:           p.Username,
+		Password:           p.Password,
+		BearerTokenFile:    p.BearerTokenFile,
+		Headers:            p.Headers,
+		MaxRetries:         p.MaxRetries,
+		RetryBackoff:       p.RetryBackoff.Duration,
+		Timeout:            p.Timeout.Duration,
+	}
+}
+
+// AlligatorConfig : the getters aggregated by one Alligator, and the endpoint they query
+type AlligatorConfig struct {
+	// name of the Prometheus endpoint; default is the first endpoint
+	Prometheus string `json:"prometheus,omitempty"`
+
+	MergePolicy     string   `json:"mergePolicy,omitempty"`
+	MergeCategories []string `json:"mergeCategories,omitempty"`
+
+	Getters []*addon.GetterConfig `json:"getters"`
+}
+
+// NewDefaultConfig returns the config used when there is no config file
+func NewDefaultConfig() *Config {
+	return &Config{
+		Server: &ServerConfig{
+			Port:          defaultPort,
+			GetterTimeout: Duration{30 * time.Second},
+
+			UnreachableTimeout: Duration{5 * time.Minute},
+		},
+		Prometheus: []*PrometheusConfig{
+			{Name: defaultPrometheus, URL: defaultPromURL},
+		},
+		App: &AlligatorConfig{
+			Getters: []*addon.GetterConfig{
+				{Name: "istio.app.metric", Category: addon.IstioGetterCategory},
+				{Name: "redis.app.metric", Category: addon.RedisGetterCategory},
+			},
+		},
+		VApp: &AlligatorConfig{
+			Getters: []*addon.GetterConfig{
+				{Name: "istio.vapp.metric", Category: addon.IstioVAppGetterCategory},
+			},
+		},
+	}
+}
+
+// LoadConfig reads the config from a json file; missing sections take the default values.
+func LoadConfig(fname string) (*Config, error) {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	//server settings missing in the file keep their default values
+	def := NewDefaultConfig()
+	conf := &Config{Server: def.Server}
+	if err := json.Unmarshal(content, conf); err != nil {
+		return nil, fmt.Errorf("Failed to parse config file %v: %v", fname, err)
+	}
+
+	if len(conf.Prometheus) < 1 {
+		conf.Prometheus = def.Prometheus
+	}
+	if conf.App == nil {
+		conf.App = def.App
+	}
+	if conf.VApp == nil {
+		conf.VApp = def.VApp
+	}
+
+	return conf, nil
+}
+
+// ApplyEnv overrides the config with the environment variables
+func (c *Config) ApplyEnv() error {
+	if v := os.Getenv(EnvPromURL); len(v) > 0 {
+		c.SetPromURL(v)
+	}
+
+	if v := os.Getenv(EnvPort); len(v) > 0 {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%v: invalid port: %v", EnvPort, v)
+		}
+		c.Server.Port = port
+	}
+
+	if v := os.Getenv(EnvScrapeInterval); len(v) > 0 {
+		du, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%v: %v", EnvScrapeInterval, err)
+		}
+		c.Server.ScrapeInterval.Duration = du
+	}
+
+	if v := os.Getenv(EnvGetterTimeout); len(v) > 0 {
+		du, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%v: %v", EnvGetterTimeout, err)
+		}
+		c.Server.GetterTimeout.Duration = du
+	}
+
+	if v := os.Getenv(EnvUnreachableTimeout); len(v) > 0 {
+		du, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%v: %v", EnvUnreachableTimeout, err)
+		}
+		c.Server.UnreachableTimeout.Duration = du
+	}
+
+	return nil
+}
+
+// SetPromURL sets the URL of the first Prometheus endpoint
+func (c *Config) SetPromURL(u string) {
+	c.FirstPrometheus().URL = u
+}
+
+// FirstPrometheus returns the first Prometheus endpoint, which the flags apply to; it is added if missing.
+func (c *Config) FirstPrometheus() *PrometheusConfig {
+	if len(c.Prometheus) < 1 {
+		c.Prometheus = append(c.Prometheus, &PrometheusConfig{Name: defaultPrometheus, URL: defaultPromURL})
+	}
+	return c.Prometheus[0]
+}
+
+// GetPrometheus returns the endpoint by name; the first endpoint if name is empty.
+func (c *Config) GetPrometheus(name string) (*PrometheusConfig, error) {
+	if len(c.Prometheus) < 1 {
+		return nil, fmt.Errorf("no Prometheus endpoint")
+	}
+
+	if len(name) < 1 {
+		return c.Prometheus[0], nil
+	}
+
+	for _, p := range c.Prometheus {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown Prometheus endpoint: %v", name)
+}
+
+// Validate checks the whole config, and reports all the errors found
+func (c *Config) Validate() error {
+	errs := []string{}
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	//1. server
+	if c.Server == nil {
+		addErr("server: section is missing")
+	} else {
+		if c.Server.Port < 1 || c.Server.Port > 65535 {
+			addErr("server.port: %d is out of range [1, 65535]", c.Server.Port)
+		}
+		if c.Server.ScrapeInterval.Duration < 0 {
+			addErr("server.scrapeInterval: should not be negative")
+		}
+		if c.Server.GetterTimeout.Duration < 0 {
+			addErr("server.getterTimeout: should not be negative")
+		}
+		if c.Server.UnreachableTimeout.Duration < 0 {
+			addErr("server.unreachableTimeout: should not be negative")
+		}
+	}
+
+	//2. prometheus endpoints
+	if len(c.Prometheus) < 1 {
+		addErr("prometheus: at least one endpoint is required")
+	}
+	names := make(map[string]bool)
+	for i, p := range c.Prometheus {
+		if len(p.Name) < 1 {
+			addErr("prometheus[%d].name: is empty", i)
+		} else if names[p.Name] {
+			addErr("prometheus[%d].name: duplicated name %v", i, p.Name)
+		}
+		names[p.Name] = true
+
+		if u, err := url.Parse(p.URL); err != nil || len(u.Host) < 1 {
+			addErr("prometheus[%d].url: invalid url [%v]", i, p.URL)
+		}
+		if err := p.ClientOptions().Validate(); err != nil {
+			addErr("prometheus[%d]: %v", i, err)
+		}
+	}
+
+	//3. alligators
+	used := make(map[string]bool)
+	for _, a := range []struct {
+		name string
+		conf *AlligatorConfig
+	}{{"app", c.App}, {"vapp", c.VApp}} {
+		if a.conf == nil {
+			addErr("%v: section is missing", a.name)
+			continue
+		}
+		for _, err := range a.conf.validate(c) {
+			addErr("%v.%v", a.name, err)
+		}
+		if p, err := c.GetPrometheus(a.conf.Prometheus); err == nil {
+			used[p.Name] = true
+		}
+	}
+
+	//4. an endpoint queried by nothing is most likely a typo in app or vapp
+	for i, p := range c.Prometheus {
+		if len(p.Name) > 0 && !used[p.Name] {
+			addErr("prometheus[%d].name: endpoint %v is not used by app or vapp", i, p.Name)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func (a *AlligatorConfig) validate(c *Config) []string {
+	errs := []string{}
+
+	if _, err := c.GetPrometheus(a.Prometheus); err != nil {
+		errs = append(errs, fmt.Sprintf("prometheus: %v", err))
+	}
+
+	if len(a.MergePolicy) > 0 {
+		if _, err := alligator.ParseMergePolicy(a.MergePolicy); err != nil {
+			errs = append(errs, fmt.Sprintf("mergePolicy: %v", err))
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, g := range a.Getters {
+		if err := g.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("getters[%d]: %v", i, err))
+			continue
+		}
+		if names[g.Name] {
+			errs = append(errs, fmt.Sprintf("getters[%d]: duplicated name %v", i, g.Name))
+		}
+		names[g.Name] = true
+	}
+
+	return errs
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"appMetric/pkg/alligator"
+)
+
+func writeConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	fname := filepath.Join(dir, "appmetric.json")
+	if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	return fname, func() { os.RemoveAll(dir) }
+}
+
+func TestNewDefaultConfig(t *testing.T) {
+	conf := NewDefaultConfig()
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Default config is invalid: %v", err)
+	}
+}
+
+func TestLoadConfig_Example(t *testing.T) {
+	conf, err := LoadConfig("../../scripts/config/appmetric.json")
+	if err != nil {
+		t.Errorf("Failed to load example config: %v", err)
+		return
+	}
+
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Example config is invalid: %v", err)
+		return
+	}
+
+	if conf.Server.ScrapeInterval.Duration != 30*time.Second || len(conf.App.Getters) != 3 {
+		t.Errorf("Wrong config: %+v", conf)
+	}
+	if p := conf.Prometheus[0]; p.MaxRetries != 2 || p.RetryBackoff.Duration != 500*time.Millisecond {
+		t.Errorf("Wrong Prometheus endpoint: %+v", p)
+	}
+
+	app, vapp, err := conf.BuildAlligators()
+	if err != nil {
+		t.Errorf("Failed to build alligators: %v", err)
+		return
+	}
+
+	if len(app.Getters) != 3 || len(vapp.Getters) != 1 {
+		t.Errorf("Wrong getters: app=%d, vapp=%d", len(app.Getters), len(vapp.Getters))
+	}
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	fname, clean := writeConfig(t, `{"server": {"port": 9000}}`)
+	defer clean()
+
+	conf, err := LoadConfig(fname)
+	if err != nil {
+		t.Errorf("Failed to load config: %v", err)
+		return
+	}
+
+	if conf.Server.Port != 9000 || conf.Server.GetterTimeout.Duration != 30*time.Second ||
+		conf.Server.UnreachableTimeout.Duration != 5*time.Minute {
+		t.Errorf("Wrong server config: %+v", conf.Server)
+	}
+
+	if len(conf.Prometheus) != 1 || len(conf.App.Getters) != 2 || len(conf.VApp.Getters) != 1 {
+		t.Errorf("Missing sections should take the default values: %+v", conf)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	fname, clean := writeConfig(t, `{
+		"server": {"port": 0},
+		"prometheus": [{"name": "p1", "url": "http://localhost:9090", "certFile": "client.crt"}, {"name": "p1", "url": "", "maxRetries": -1},
+			{"name": "p3", "url": "http://localhost:9091"}],
+		"app": {"prometheus": "p2", "mergePolicy": "prefer-last", "getters": [
+			{"name": "g1", "category": "Istio", "window": "3 minutes"},
+			{"name": "g2", "category": "Unknown"},
+			{"name": "g3", "category": "Redis"},
+			{"name": "g3", "category": "Redis"},
+			{"name": "g4", "category": "Redis", "identity": "port"},
+			{"name": "g5", "category": "Istio", "identity": "ip"},
+			{"name": "g6", "category": "Redis", "metricGroups": ["cache", "keys"]},
+			{"name": "g7", "category": "Redis", "window": "0m"}
+		]}
+	}`)
+	defer clean()
+
+	conf, err := LoadConfig(fname)
+	if err != nil {
+		t.Errorf("Failed to load config: %v", err)
+		return
+	}
+
+	err = conf.Validate()
+	if err == nil {
+		t.Errorf("Validation should have failed.")
+		return
+	}
+
+	expects := []string{
+		"server.port",
+		"prometheus[1].name: duplicated",
+		"prometheus[1].url",
+		"prometheus[0]: certFile and keyFile should be set together",
+		"prometheus[1]: maxRetries should not be negative",
+		"prometheus[2].name: endpoint p3 is not used by app or vapp",
+		"app.prometheus: unknown Prometheus endpoint: p2",
+		"app.mergePolicy",
+		"app.getters[0]: getter g1: invalid window",
+		"app.getters[1]: getter g2: unknown category",
+		"app.getters[3]: duplicated name g3",
+		"app.getters[4]: getter g4: invalid redis identity",
+		"app.getters[5]: getter g5: identity is only for category Redis",
+		"app.getters[6]: getter g6: invalid redis metric group",
+		"app.getters[7]: getter g7: invalid window: [0m], should be positive",
+	}
+	for _, e := range expects {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("Expected error [%v] is not reported:\n%v", e, err)
+		}
+	}
+}
+
+func TestConfig_ApplyEnv(t *testing.T) {
+	os.Setenv(EnvPromURL, "http://prometheus:9090")
+	os.Setenv(EnvPort, "9001")
+	defer os.Unsetenv(EnvPromURL)
+	defer os.Unsetenv(EnvPort)
+
+	conf := NewDefaultConfig()
+	if err := conf.ApplyEnv(); err != nil {
+		t.Errorf("Failed to apply env: %v", err)
+		return
+	}
+
+	if conf.Prometheus[0].URL != "http://prometheus:9090" || conf.Server.Port != 9001 {
+		t.Errorf("Env is not applied: %+v, %+v", conf.Prometheus[0], conf.Server)
+	}
+
+	os.Setenv(EnvPort, "port")
+	if err := conf.ApplyEnv(); err == nil {
+		t.Errorf("Applying invalid port should have failed.")
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	fname, clean := writeConfig(t, `{"app": {"getters": [{"name": "g1", "category": "Redis"}]}}`)
+	defer clean()
+
+	build := func() (*Config, *alligator.Alligator, *alligator.Alligator, error) {
+		conf, err := LoadConfig(fname)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := conf.Validate(); err != nil {
+			return nil, nil, nil, err
+		}
+		app, vapp, err := conf.BuildAlligators()
+		return conf, app, vapp, err
+	}
+
+	conf, app, vapp, err := build()
+	if err != nil {
+		t.Errorf("Failed to build: %v", err)
+		return
+	}
+	r := NewReloader(conf, app, vapp, build, []string{fname}, time.Second)
+
+	//1. an invalid config is rejected
+	ioutil.WriteFile(fname, []byte(`{"app": {"getters": [{"name": "g2", "category": "Unknown"}]}}`), 0644)
+	if !r.changed() {
+		t.Errorf("File change is not detected.")
+	}
+	if err := r.Reload(); err == nil {
+		t.Errorf("Reload with invalid config should have failed.")
+	}
+	if names := app.GetterNames(); len(names) != 1 || names[0] != "g1" {
+		t.Errorf("The old getters should be kept: %v", names)
+	}
+
+	//2. a valid config is applied
+	ioutil.WriteFile(fname, []byte(`{"app": {"getters": [{"name": "g2", "category": "Redis"}, {"name": "g3", "category": "Istio"}]}}`), 0644)
+	if !r.changed() {
+		t.Errorf("File change is not detected.")
+	}
+	if err := r.Reload(); err != nil {
+		t.Errorf("Failed to reload: %v", err)
+	}
+	if names := app.GetterNames(); len(names) != 2 || names[0] != "g2" {
+		t.Errorf("The new getters are not applied: %v", names)
+	}
+
+	if r.changed() {
+		t.Errorf("File is not changed since last check.")
+	}
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+
+	"appMetric/pkg/alligator"
+)
+
+// BuildFunc loads the config, and builds the Alligators for Applications and VirtualApplications
+type BuildFunc func() (*Config, *alligator.Alligator, *alligator.Alligator, error)
+
+// Reloader : rebuilds the getter sets of the Alligators when the watched files change,
+// or when SIGHUP is received, while the server keeps serving.
+// An invalid config is rejected, and the old getter sets are kept.
+type Reloader struct {
+	files    []string
+	build    BuildFunc
+	interval time.Duration
+
+	app  *alligator.Alligator
+	vapp *alligator.Alligator
+
+	// the config currently in use
+	conf *Config
+	// checksum of the watched files
+	sums map[string][sha256.Size]byte
+}
+
+// NewReloader creates a Reloader for the Alligators built with conf;
+// files are checked for changes on every interval, and not watched if interval is not positive.
+func NewReloader(conf *Config, app, vapp *alligator.Alligator, build BuildFunc, files []string, interval time.Duration) *Reloader {
+	r := &Reloader{
+		files:    files,
+		build:    build,
+		interval: interval,
+		app:      app,
+		vapp:     vapp,
+		conf:     conf,
+		sums:     make(map[string][sha256.Size]byte),
+	}
+
+	r.changed()
+	return r
+}
+
+// Run watches the files and SIGHUP, until stop is closed.
+func (r *Reloader) Run(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	glog.V(1).Infof("Begin to watch config files %v every %v, and SIGHUP", r.files, r.interval)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			glog.V(1).Infof("Received SIGHUP, reload the config.")
+			r.changed()
+			r.Reload()
+		case <-tick:
+			if r.changed() {
+				glog.V(1).Infof("Config files changed, reload the config.")
+				r.Reload()
+			}
+		}
+	}
+}
+
+// Reload rebuilds the getter sets, and replaces the ones of the Alligators on success.
+func (r *Reloader) Reload() error {
+	conf, app, vapp, err := r.build()
+	if err != nil {
+		glog.Errorf("Reject the new config, and keep the old one:\n%v", err)
+		return err
+	}
+
+	r.warnRestart(conf)
+	r.app.Reload(app)
+	r.vapp.Reload(vapp)
+	r.conf = conf
+	glog.V(1).Infof("Config reloaded: app getters %v, vapp getters %v", r.app.GetterNames(), r.vapp.GetterNames())
+	return nil
+}
+
+// the server settings only take effect after restart
+func (r *Reloader) warnRestart(conf *Config) {
+	if r.conf == nil || r.conf.Server == nil || conf.Server == nil {
+		return
+	}
+
+	old, cur := r.conf.Server, conf.Server
+	if old.Port != cur.Port || old.ScrapeInterval != cur.ScrapeInterval || old.UnreachableTimeout != cur.UnreachableTimeout {
+		glog.Warningf("Changes of server.port, server.scrapeInterval and server.unreachableTimeout take effect after restart.")
+	}
+}
+
+// changed updates the checksums of the files, and returns true if any of them changed
+func (r *Reloader) changed() bool {
+	result := false
+	for _, fname := range r.files {
+		content, err := ioutil.ReadFile(fname)
+		if err != nil {
+			glog.Errorf("Failed to read %v: %v", fname, err)
+			continue
+		}
+
+		sum := sha256.Sum256(content)
+		if old, exist := r.sums[fname]; !exist || old != sum {
+			r.sums[fname] = sum
+			result = result || exist
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package exposition
+
+import (
+	"fmt"
+	"sort"
+
+	"appMetric/pkg/inter"
+)
+
+// EntityMetricPrefix : the prefix of the gauges of the entity metrics, such as appmetric_entity_tps
+const EntityMetricPrefix = "appmetric_entity_"
+
+// the labels set on every entity gauge; the entity labels of the same names are dropped
+const (
+	LabelUID  = "uid"
+	LabelType = "type"
+)
+
+var entityTypeNames = map[int32]string{
+	inter.ApplicationType:        "Application",
+	inter.VirtualApplicationType: "VirtualApplication",
+	inter.VirtualMachineType:     "VirtualMachine",
+}
+
+// EntityTypeName returns the name of the entity type, or its number if unknown
+func EntityTypeName(t int32) string {
+	if name, exist := entityTypeNames[t]; exist {
+		return name
+	}
+	return fmt.Sprintf("%d", t)
+}
+
+// EntityFamilies converts the entity metrics into gauges, one family per metric name,
+// such as appmetric_entity_tps{uid="10.2.1.104",type="Application",category="Istio",name="default/productpage-v1"}.
+// The entities are sorted by UID, and their labels by name.
+func EntityFamilies(entities []*inter.EntityMetric) []*Family {
+	sorted := make([]*inter.EntityMetric, len(entities))
+	copy(sorted, entities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].UID < sorted[j].UID
+	})
+
+	families := make(map[string]*Family)
+	result := []*Family{}
+	for _, e := range sorted {
+		labels := entityLabels(e)
+
+		names := make([]string, 0, len(e.Metrics))
+		for name := range e.Metrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			// metric names differing only in the invalid characters share a family
+			fname := SanitizeMetricName(EntityMetricPrefix + name)
+			f, exist := families[fname]
+			if !exist {
+				f = NewFamily(fname, fmt.Sprintf("The %v of the entities.", name), TypeGauge)
+				families[fname] = f
+				result = append(result, f)
+			}
+			f.AddSample(e.Metrics[name], labels...)
+		}
+	}
+
+	return result
+}
+
+// entityLabels returns the uid, type, and the sanitized labels of the entity.
+// If several labels are sanitized into the same name, the first one in order of the original names is kept.
+func entityLabels(e *inter.EntityMetric) []Label {
+	result := []Label{
+		{Name: LabelUID, Value: e.UID},
+		{Name: LabelType, Value: EntityTypeName(e.Type)},
+	}
+
+	keys := make([]string, 0, len(e.Labels))
+	for k := range e.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	used := map[string]bool{LabelUID: true, LabelType: true}
+	for _, k := range keys {
+		name := SanitizeLabelName(k)
+		if used[name] {
+			continue
+		}
+		used[name] = true
+		result = append(result, Label{Name: name, Value: e.Labels[k]})
+	}
+	return result
+}
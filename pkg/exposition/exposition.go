@@ -0,0 +1,171 @@
+package exposition
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// the metric types
+const (
+	TypeGauge     = "gauge"
+	TypeCounter   = "counter"
+	TypeHistogram = "histogram"
+)
+
+// Label : a label of a sample; the name should be sanitized by SanitizeLabelName
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample : one sample of a metric family
+type Sample struct {
+	// appended to the name of the family, such as "_bucket" of a histogram
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Family : the samples of one metric, with its help and type
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []*Sample
+}
+
+func NewFamily(name, help, mtype string) *Family {
+	return &Family{
+		Name:    SanitizeMetricName(name),
+		Help:    help,
+		Type:    mtype,
+		Samples: []*Sample{},
+	}
+}
+
+func (f *Family) AddSample(value float64, labels ...Label) {
+	f.Samples = append(f.Samples, &Sample{Labels: labels, Value: value})
+}
+
+// AddSuffixSample adds a sample named with the suffix, such as the "_sum" of a histogram
+func (f *Family) AddSuffixSample(suffix string, value float64, labels ...Label) {
+	f.Samples = append(f.Samples, &Sample{Suffix: suffix, Labels: labels, Value: value})
+}
+
+// WriteFamilies writes the families in the text exposition format, sorted by name.
+// Families with no samples are skipped.
+func WriteFamilies(w io.Writer, families []*Family) error {
+	sorted := make([]*Family, len(families))
+	copy(sorted, families)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range sorted {
+		if len(f.Samples) < 1 {
+			continue
+		}
+
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name)
+			bw.WriteString(s.Suffix)
+			writeLabels(bw, s.Labels)
+			bw.WriteString(" ")
+			bw.WriteString(FormatValue(s.Value))
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func writeLabels(w *bufio.Writer, labels []Label) {
+	if len(labels) < 1 {
+		return
+	}
+
+	w.WriteString("{")
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString(l.Name)
+		w.WriteString(`="`)
+		w.WriteString(escapeLabelValue(l.Value))
+		w.WriteString(`"`)
+	}
+	w.WriteString("}")
+}
+
+// FormatValue formats the value as in the exposition format, such as "+Inf"
+func FormatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
+
+// SanitizeMetricName makes a valid metric name, matching [a-zA-Z_:][a-zA-Z0-9_:]*,
+// by replacing the invalid characters with "_".
+func SanitizeMetricName(name string) string {
+	return sanitize(name, true)
+}
+
+// SanitizeLabelName makes a valid label name, matching [a-zA-Z_][a-zA-Z0-9_]*,
+// by replacing the invalid characters with "_"; the names beginning with "__" are reserved, and get only one "_".
+func SanitizeLabelName(name string) string {
+	result := sanitize(name, false)
+	if strings.HasPrefix(result, "__") {
+		result = "_" + strings.TrimLeft(result, "_")
+	}
+	return result
+}
+
+func sanitize(name string, colon bool) string {
+	if len(name) < 1 {
+		return "_"
+	}
+
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9' && i > 0) || (c == ':' && colon)
+		if !valid {
+			b[i] = '_'
+		}
+	}
+
+	// a leading digit is kept after "_"
+	if name[0] >= '0' && name[0] <= '9' {
+		return "_" + name[:1] + string(b[1:])
+	}
+	return string(b)
+}
@@ -0,0 +1,65 @@
+package exposition
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"appMetric/pkg/inter"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		label  string
+	}{
+		{"tps", "tps", "tps"},
+		{"Istio.tps", "Istio_tps", "Istio_tps"},
+		{"a:b-c", "a:b_c", "a_b_c"},
+		{"99th", "_99th", "_99th"},
+		{"__name__", "__name__", "_name__"},
+		{"", "_", "_"},
+	}
+
+	for _, test := range tests {
+		if v := SanitizeMetricName(test.name); v != test.metric {
+			t.Errorf("Metric name of [%v]: expected %v, got %v", test.name, test.metric, v)
+		}
+		if v := SanitizeLabelName(test.name); v != test.label {
+			t.Errorf("Label name of [%v]: expected %v, got %v", test.name, test.label, v)
+		}
+	}
+}
+
+func TestWriteFamilies_Entities(t *testing.T) {
+	e1 := inter.NewEntityMetric("default/productpage", inter.VirtualApplicationType)
+	e1.SetLabel(inter.Name, "default/productpage")
+	e1.SetLabel(inter.Category, "Istio.VApp")
+	e1.SetMetric(inter.TPS, 8)
+
+	e2 := inter.NewEntityMetric("10.2.1.104", inter.ApplicationType)
+	e2.SetLabel(inter.Category, "Istio")
+	e2.SetLabel("uid", "dropped")
+	e2.SetLabel("app.kubernetes.io/name", "web \"v1\"\n")
+	e2.SetMetric(inter.TPS, 1.5)
+	e2.SetMetric(inter.Latency, math.NaN())
+
+	var buf bytes.Buffer
+	if err := WriteFamilies(&buf, EntityFamilies([]*inter.EntityMetric{e1, e2})); err != nil {
+		t.Errorf("Failed to write families: %v", err)
+		return
+	}
+
+	expected := `# HELP appmetric_entity_latency The latency of the entities.
+# TYPE appmetric_entity_latency gauge
+appmetric_entity_latency{uid="10.2.1.104",type="Application",app_kubernetes_io_name="web \"v1\"\n",category="Istio"} NaN
+# HELP appmetric_entity_tps The tps of the entities.
+# TYPE appmetric_entity_tps gauge
+appmetric_entity_tps{uid="10.2.1.104",type="Application",app_kubernetes_io_name="web \"v1\"\n",category="Istio"} 1.5
+appmetric_entity_tps{uid="default/productpage",type="VirtualApplication",category="Istio.VApp",name="default/productpage"} 8
+`
+	if buf.String() != expected {
+		t.Errorf("Wrong exposition:\n%v\nexpected:\n%v", buf.String(), expected)
+	}
+}
@@ -0,0 +1,195 @@
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"appMetric/pkg/inter"
+)
+
+// the query parameters of the filter
+const (
+	ParamCategory  = "category"
+	ParamNamespace = "namespace"
+	ParamType      = "type"
+	ParamLabel     = "label"
+	ParamSelector  = "selector"
+)
+
+// EntityFilter : selects the entities by their type and labels; the empty filter selects everything.
+type EntityFilter struct {
+	// any of the categories; an entity merged from several categories matches any of them
+	Categories []string
+	// any of the namespaces, from the "namespace/name" of the name label
+	Namespaces []string
+	// any of the entity types
+	Types []int32
+	// all the label requirements, from both the label and the selector parameters
+	Selector Selector
+}
+
+// ParseEntityFilter gets the filter from the query parameters, such as
+// "?category=Redis", "?namespace=default", "?type=Application", "?label=ip=10.2.1.104" (repeatable),
+// and "?selector=app in (web, api),tier!=db".
+// The values of category, namespace and type can be separated by ",".
+func ParseEntityFilter(params url.Values) (*EntityFilter, error) {
+	f := &EntityFilter{
+		Categories: splitValues(params[ParamCategory]),
+		Namespaces: splitValues(params[ParamNamespace]),
+		Selector:   Selector{},
+	}
+
+	for _, v := range splitValues(params[ParamType]) {
+		t, err := parseEntityType(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Types = append(f.Types, t)
+	}
+
+	for _, v := range params[ParamLabel] {
+		items := strings.SplitN(v, "=", 2)
+		if len(items) != 2 || len(items[0]) < 1 {
+			return nil, fmt.Errorf("invalid label [%v], should be key=value", v)
+		}
+		f.Selector = append(f.Selector, &Requirement{Key: items[0], Operator: OpEquals, Values: []string{items[1]}})
+	}
+
+	for _, v := range params[ParamSelector] {
+		s, err := ParseSelector(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Selector = append(f.Selector, s...)
+	}
+
+	return f, nil
+}
+
+func splitValues(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// parseEntityType parses the type by number, such as "1", or by name, such as "Application"
+func parseEntityType(v string) (int32, error) {
+	switch strings.ToLower(v) {
+	case "application", "app":
+		return inter.ApplicationType, nil
+	case "virtualapplication", "vapp":
+		return inter.VirtualApplicationType, nil
+	case "virtualmachine", "vm":
+		return inter.VirtualMachineType, nil
+	}
+
+	t, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid entity type [%v]", v)
+	}
+	return int32(t), nil
+}
+
+// IsEmpty returns true if the filter selects everything
+func (f *EntityFilter) IsEmpty() bool {
+	return f == nil || (len(f.Categories) < 1 && len(f.Namespaces) < 1 && len(f.Types) < 1 && len(f.Selector) < 1)
+}
+
+// Matches returns true if an entity with the type and labels is selected
+func (f *EntityFilter) Matches(etype int32, labels map[string]string) bool {
+	if f.IsEmpty() {
+		return true
+	}
+
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			found = found || t == etype
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Categories) > 0 && !containsAny(f.Categories, entityCategories(labels)) {
+		return false
+	}
+
+	if len(f.Namespaces) > 0 {
+		ns, ok := entityNamespace(labels)
+		if !ok || !containsAny(f.Namespaces, []string{ns}) {
+			return false
+		}
+	}
+
+	return f.Selector.Matches(labels)
+}
+
+// FilterEntities returns the selected entities
+func (f *EntityFilter) FilterEntities(entities []*inter.EntityMetric) []*inter.EntityMetric {
+	if f.IsEmpty() {
+		return entities
+	}
+
+	result := []*inter.EntityMetric{}
+	for _, e := range entities {
+		if f.Matches(e.Type, e.Labels) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// FilterSeries returns the selected entity series
+func (f *EntityFilter) FilterSeries(series []*inter.EntitySeries) []*inter.EntitySeries {
+	if f.IsEmpty() {
+		return series
+	}
+
+	result := []*inter.EntitySeries{}
+	for _, s := range series {
+		if f.Matches(s.Type, s.Labels) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// the categories of an entity: all the contributing ones if it is merged
+func entityCategories(labels map[string]string) []string {
+	if v, exist := labels[inter.Categories]; exist {
+		return strings.Split(v, ",")
+	}
+	if v, exist := labels[inter.Category]; exist {
+		return []string{v}
+	}
+	return nil
+}
+
+// the namespace of an entity, from its name label "namespace/name"
+func entityNamespace(labels map[string]string) (string, bool) {
+	items := strings.SplitN(labels[inter.Name], "/", 2)
+	if len(items) != 2 || len(items[0]) < 1 {
+		return "", false
+	}
+	return items[0], true
+}
+
+func containsAny(values []string, targets []string) bool {
+	for _, v := range values {
+		for _, t := range targets {
+			if v == t {
+				return true
+			}
+		}
+	}
+	return false
+}
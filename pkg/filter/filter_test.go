@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"net/url"
+	"testing"
+
+	"appMetric/pkg/inter"
+)
+
+func TestParseSelector(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "frontend", "env": "prod"}
+
+	tests := []struct {
+		selector string
+		match    bool
+	}{
+		{"", true},
+		{"app=web", true},
+		{"app==web,tier=frontend", true},
+		{"app!=web", false},
+		{"release!=canary", true},
+		{"env in (prod, staging)", true},
+		{"env in (staging),app=web", false},
+		{"env notin (staging, dev)", true},
+		{"release notin (canary)", true},
+		{"app", true},
+		{"release", false},
+		{"!release", true},
+		{"!app", false},
+	}
+
+	for _, test := range tests {
+		s, err := ParseSelector(test.selector)
+		if err != nil {
+			t.Errorf("Failed to parse selector [%v]: %v", test.selector, err)
+			continue
+		}
+		if s.Matches(labels) != test.match {
+			t.Errorf("Selector [%v] should match %v: %v", test.selector, test.match, labels)
+		}
+	}
+
+	for _, selector := range []string{"=web", "app in (a b)", "app=(web)", "a pp=web"} {
+		if _, err := ParseSelector(selector); err == nil {
+			t.Errorf("Selector [%v] should be invalid", selector)
+		}
+	}
+}
+
+func newEntity(uid string, etype int32, labels map[string]string) *inter.EntityMetric {
+	e := inter.NewEntityMetric(uid, etype)
+	for k, v := range labels {
+		e.SetLabel(k, v)
+	}
+	return e
+}
+
+func TestEntityFilter_FilterEntities(t *testing.T) {
+	entities := []*inter.EntityMetric{
+		newEntity("10.2.1.104", inter.ApplicationType, map[string]string{
+			inter.Category: "Istio", inter.Name: "default/productpage-v1", inter.IP: "10.2.1.104"}),
+		newEntity("10.2.2.65", inter.ApplicationType, map[string]string{
+			inter.Category: "Redis", inter.IP: "10.2.2.65", inter.Port: "6379"}),
+		newEntity("10.2.2.66", inter.ApplicationType, map[string]string{
+			inter.Category: "Istio", inter.Categories: "Istio,Redis", inter.Name: "cache/redis-0", inter.IP: "10.2.2.66"}),
+		newEntity("default/productpage", inter.VirtualApplicationType, map[string]string{
+			inter.Category: "Istio.VApp", inter.Name: "default/productpage"}),
+	}
+
+	tests := []struct {
+		query  string
+		expect []string
+	}{
+		{"", []string{"10.2.1.104", "10.2.2.65", "10.2.2.66", "default/productpage"}},
+		{"category=Redis", []string{"10.2.2.65", "10.2.2.66"}},
+		{"category=Redis,Istio.VApp", []string{"10.2.2.65", "10.2.2.66", "default/productpage"}},
+		{"namespace=default", []string{"10.2.1.104", "default/productpage"}},
+		{"type=Application&namespace=default", []string{"10.2.1.104"}},
+		{"type=2", []string{"default/productpage"}},
+		{"label=ip=10.2.1.104", []string{"10.2.1.104"}},
+		{"label=ip=10.2.1.104&label=category=Redis", []string{}},
+		{"selector=" + url.QueryEscape("category in (Redis, Istio),!port"), []string{"10.2.1.104", "10.2.2.66"}},
+		{"category=Istio&selector=name", []string{"10.2.1.104", "10.2.2.66"}},
+	}
+
+	for _, test := range tests {
+		params, _ := url.ParseQuery(test.query)
+		f, err := ParseEntityFilter(params)
+		if err != nil {
+			t.Errorf("Failed to parse filter [%v]: %v", test.query, err)
+			continue
+		}
+
+		result := f.FilterEntities(entities)
+		if len(result) != len(test.expect) {
+			t.Errorf("Filter [%v]: expected %v, got %d entities", test.query, test.expect, len(result))
+			continue
+		}
+		for i, e := range result {
+			if e.UID != test.expect[i] {
+				t.Errorf("Filter [%v]: expected %v, got %v", test.query, test.expect[i], e.UID)
+			}
+		}
+	}
+
+	for _, query := range []string{"type=pod", "label=ip", "selector=%3Dweb"} {
+		params, _ := url.ParseQuery(query)
+		if _, err := ParseEntityFilter(params); err == nil {
+			t.Errorf("Filter [%v] should be invalid", query)
+		}
+	}
+}
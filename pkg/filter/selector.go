@@ -0,0 +1,148 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Operators of the label requirements, as the Kubernetes label selector
+const (
+	OpEquals       = "="
+	OpNotEquals    = "!="
+	OpIn           = "in"
+	OpNotIn        = "notin"
+	OpExists       = "exists"
+	OpDoesNotExist = "!"
+)
+
+var (
+	keyRegex = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+	setRegex = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// Requirement : a requirement on one label, such as "tier!=db", or "app in (web, api)"
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Matches returns true if the labels meet the requirement
+func (r *Requirement) Matches(labels map[string]string) bool {
+	value, exist := labels[r.Key]
+
+	switch r.Operator {
+	case OpExists:
+		return exist
+	case OpDoesNotExist:
+		return !exist
+	case OpEquals, OpIn:
+		return exist && r.hasValue(value)
+	case OpNotEquals, OpNotIn:
+		return !exist || !r.hasValue(value)
+	}
+	return false
+}
+
+func (r *Requirement) hasValue(value string) bool {
+	for _, v := range r.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Selector : the requirements which should all be met
+type Selector []*Requirement
+
+// Matches returns true if the labels meet all the requirements; an empty Selector matches everything.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses a selector in Kubernetes label selector syntax, such as
+// "app=web,tier!=db", "env in (prod, staging),release notin (canary)", "team", or "!deprecated".
+func ParseSelector(selector string) (Selector, error) {
+	result := Selector{}
+
+	for _, term := range splitTerms(selector) {
+		term = strings.TrimSpace(term)
+		if len(term) < 1 {
+			continue
+		}
+
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+
+	return result, nil
+}
+
+// splitTerms splits the selector by the commas outside of parentheses
+func splitTerms(selector string) []string {
+	result := []string{}
+	depth, begin := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				result = append(result, selector[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	return append(result, selector[begin:])
+}
+
+func parseRequirement(term string) (*Requirement, error) {
+	r := &Requirement{}
+
+	switch {
+	case setRegex.MatchString(term):
+		items := setRegex.FindStringSubmatch(term)
+		r.Key, r.Operator = items[1], items[2]
+		for _, v := range strings.Split(items[3], ",") {
+			r.Values = append(r.Values, strings.TrimSpace(v))
+		}
+	case strings.Contains(term, "!="):
+		items := strings.SplitN(term, "!=", 2)
+		r.Key, r.Operator, r.Values = items[0], OpNotEquals, []string{items[1]}
+	case strings.Contains(term, "=="):
+		items := strings.SplitN(term, "==", 2)
+		r.Key, r.Operator, r.Values = items[0], OpEquals, []string{items[1]}
+	case strings.Contains(term, "="):
+		items := strings.SplitN(term, "=", 2)
+		r.Key, r.Operator, r.Values = items[0], OpEquals, []string{items[1]}
+	case strings.HasPrefix(term, "!"):
+		r.Key, r.Operator = term[1:], OpDoesNotExist
+	default:
+		r.Key, r.Operator = term, OpExists
+	}
+
+	r.Key = strings.TrimSpace(r.Key)
+	if !keyRegex.MatchString(r.Key) {
+		return nil, fmt.Errorf("invalid selector [%v]: bad label key [%v]", term, r.Key)
+	}
+	for i := range r.Values {
+		r.Values[i] = strings.TrimSpace(r.Values[i])
+		if strings.ContainsAny(r.Values[i], "()=! ") {
+			return nil, fmt.Errorf("invalid selector [%v]: bad label value [%v]", term, r.Values[i])
+		}
+	}
+
+	return r, nil
+}
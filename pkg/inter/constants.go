@@ -9,10 +9,25 @@ const (
 	//CommodityType
 	TPS     = "tps"
 	Latency = "latency"
+	//latency percentile, such as "latency_p99"
+	LatencyPercentilePrefix = "latency_p"
+	//request rates by response code: all, 2xx, 4xx, 5xx; and (4xx + 5xx)/all
+	RequestRate  = "request_rate"
+	SuccessRate  = "success_rate"
+	Error4xxRate = "error_4xx_rate"
+	Error5xxRate = "error_5xx_rate"
+	ErrorRatio   = "error_ratio"
 
 	//Labels
 	IP       = "ip"
 	Port     = "port"
 	Name     = "name"
 	Category = "category"
+	//categories of merged entity, separated by ","
+	Categories = "categories"
+
+	//Response Status
+	StatusSuccess = 0
+	StatusPartial = 1
+	StatusFailure = -1
 )
@@ -0,0 +1,49 @@
+package inter
+
+// Health status
+const (
+	HealthOK       = "ok"
+	HealthReady    = "ready"
+	HealthNotReady = "not-ready"
+	HealthDegraded = "degraded"
+)
+
+// HealthCheck : the readiness of one Alligator
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// (unix) times of the last successful scrape, and of the first failure since then
+	LastSuccess  int64  `json:"lastSuccess,omitempty"`
+	FailingSince int64  `json:"failingSince,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// HealthResponse : the response of the health and readiness endpoints
+type HealthResponse struct {
+	Status string         `json:"status"`
+	Checks []*HealthCheck `json:"checks,omitempty"`
+}
+
+func NewHealthResponse(status string) *HealthResponse {
+	return &HealthResponse{
+		Status: status,
+	}
+}
+
+// AddCheck adds the check; the status of the response is the worst of the checks
+func (r *HealthResponse) AddCheck(c *HealthCheck) {
+	r.Checks = append(r.Checks, c)
+	if healthRank(c.Status) > healthRank(r.Status) {
+		r.Status = c.Status
+	}
+}
+
+func healthRank(status string) int {
+	switch status {
+	case HealthDegraded:
+		return 1
+	case HealthNotReady:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package inter
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Sample : the value of a metric at a unix timestamp (seconds); in json as [timestamp, value]
+type Sample struct {
+	Timestamp int64
+	Value     float64
+}
+
+func (s *Sample) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{s.Timestamp, s.Value})
+}
+
+func (s *Sample) UnmarshalJSON(b []byte) error {
+	var v []float64
+	if err := json.Unmarshal(b, &v); err != nil || len(v) != 2 {
+		return fmt.Errorf("sample should be [timestamp, value]: %v", string(b))
+	}
+	s.Timestamp = int64(v[0])
+	s.Value = v[1]
+	return nil
+}
+
+// EntitySeries : the metrics of an entity over a time range
+type EntitySeries struct {
+	UID     string               `json:"uid"`
+	Type    int32                `json:"type,omitempty"`
+	Labels  map[string]string    `json:"labels,omitempty"`
+	Metrics map[string][]*Sample `json:"metrics,omitempty"`
+}
+
+func NewEntitySeries(id string, t int32) *EntitySeries {
+	return &EntitySeries{
+		UID:     id,
+		Type:    t,
+		Labels:  make(map[string]string),
+		Metrics: make(map[string][]*Sample),
+	}
+}
+
+func (e *EntitySeries) SetLabel(name, value string) {
+	e.Labels[name] = value
+}
+
+// AddSample appends a sample to the series of the metric; samples should be added in time order.
+func (e *EntitySeries) AddSample(name string, timestamp int64, value float64) {
+	e.Metrics[name] = append(e.Metrics[name], &Sample{Timestamp: timestamp, Value: value})
+}
+
+// RangeResponse : the response of the range API
+type RangeResponse struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message,omitempty"`
+	Data    []*EntitySeries `json:"data"`
+	Getters []*GetterStatus `json:"getters,omitempty"`
+
+	// the range: unix timestamps, and the step in seconds
+	Start  int64   `json:"start"`
+	End    int64   `json:"end"`
+	Step   float64 `json:"stepSeconds"`
+	Window string  `json:"window,omitempty"`
+
+	// the unit of each metric in data, such as "latency": "ms"
+	Units map[string]string `json:"units,omitempty"`
+}
+
+func NewRangeResponse() *RangeResponse {
+	return &RangeResponse{
+		Status: StatusSuccess,
+		Data:   []*EntitySeries{},
+	}
+}
+
+func (r *RangeResponse) SetStatus(v int, msg string) {
+	r.Status = v
+	r.Message = msg
+}
@@ -1,5 +1,9 @@
 package inter
 
+import (
+	"time"
+)
+
 type EntityMetric struct {
 	UID     string             `json:"uid"`
 	Type    int32              `json:"type,omitempty"`
@@ -26,15 +30,64 @@ func (e *EntityMetric) SetMetric(name string, value float64) {
 	e.Metrics[name] = value
 }
 
+// GetterStatus : the outcome of one entity getter in a scrape
+type GetterStatus struct {
+	Name        string  `json:"name"`
+	Category    string  `json:"category"`
+	Success     bool    `json:"success"`
+	Error       string  `json:"error,omitempty"`
+	EntityCount int     `json:"entityCount"`
+	Duration    float64 `json:"durationMs"`
+	// the rate window of the queries, such as "3m"
+	Window string `json:"window,omitempty"`
+	// the getter failed, and its entities are kept from the last scrape
+	Stale bool `json:"stale,omitempty"`
+}
+
+func NewGetterStatus(name, category string) *GetterStatus {
+	return &GetterStatus{
+		Name:     name,
+		Category: category,
+		Success:  true,
+	}
+}
+
+func (s *GetterStatus) SetError(err error) {
+	s.Success = false
+	s.Error = err.Error()
+}
+
 type MetricResponse struct {
 	Status  int             `json:"status"`
 	Message string          `json:"message:omitemtpy"`
 	Data    []*EntityMetric `json:"data:omitempty"`
+	Getters []*GetterStatus `json:"getters,omitempty"`
+
+	// the (unix) time of the metrics: only set when served from a cached snapshot, or queried at a time
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// only set when served from a cached snapshot
+	Age float64 `json:"ageSeconds,omitempty"`
+
+	// only set when the rate window is overridden by the request
+	Window string `json:"window,omitempty"`
+}
+
+// ErrorResponse : the response of a failed request which has no metrics, such as an unknown entity
+type ErrorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func NewErrorResponse(msg string) *ErrorResponse {
+	return &ErrorResponse{
+		Status:  StatusFailure,
+		Message: msg,
+	}
 }
 
 func NewMetricResponse() *MetricResponse {
 	return &MetricResponse{
-		Status:  0,
+		Status:  StatusSuccess,
 		Message: "",
 		Data:    []*EntityMetric{},
 	}
@@ -52,3 +105,23 @@ func (r *MetricResponse) SetMetrics(dat []*EntityMetric) {
 func (r *MetricResponse) AddMetric(m *EntityMetric) {
 	r.Data = append(r.Data, m)
 }
+
+func (r *MetricResponse) SetGetterStatus(getters []*GetterStatus) {
+	r.Getters = getters
+}
+
+// SetWindow sets the rate window used by all the getters
+func (r *MetricResponse) SetWindow(window string) {
+	r.Window = window
+}
+
+// SetQueryTime sets the (unix) time the metrics were queried at
+func (r *MetricResponse) SetQueryTime(t time.Time) {
+	r.Timestamp = t.Unix()
+}
+
+// SetScrapeTime sets the (unix) time the metrics were scraped, and their age in seconds
+func (r *MetricResponse) SetScrapeTime(t time.Time) {
+	r.Timestamp = t.Unix()
+	r.Age = time.Since(t).Seconds()
+}
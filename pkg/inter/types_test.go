@@ -110,3 +110,90 @@ func TestNewMetricResponse2(t *testing.T) {
 
 	fmt.Printf("%+v\n", mr)
 }
+
+func TestMetricUnit(t *testing.T) {
+	tests := map[string]string{
+		TPS:                 "1/s",
+		Latency:             "ms",
+		"latency_p99":       "ms",
+		ErrorRatio:          "ratio",
+		"Istio.latency":     "ms",
+		"memory_used_bytes": "bytes",
+		"hit_ratio":         "ratio",
+		"evicted_keys_rate": "1/s",
+		"command_rate_get":  "1/s",
+		"connected_clients": "count",
+		"readLatency":       "",
+	}
+
+	for name, unit := range tests {
+		if u := MetricUnit(name); u != unit {
+			t.Errorf("Unit of %v: expected [%v], got [%v]", name, unit, u)
+		}
+	}
+}
+
+func TestNewMetricResponseV2(t *testing.T) {
+	em := NewEntityMetric("aid1", ApplicationType)
+	em.SetMetric(Latency, 133.2)
+	em.SetMetric("readLatency", 50)
+
+	res := NewMetricResponse()
+	res.SetStatus(StatusSuccess, "Success")
+	res.SetMetrics([]*EntityMetric{em})
+	res.SetWindow("1m")
+
+	ebytes, err := json.Marshal(NewMetricResponseV2(res))
+	if err != nil {
+		t.Errorf("Failed to marshall MetricResponseV2 %+v", res)
+		return
+	}
+
+	var v2 map[string]interface{}
+	if err = json.Unmarshal(ebytes, &v2); err != nil {
+		t.Errorf("Failed to un-marshal bytes: %v", string(ebytes))
+		return
+	}
+
+	for _, key := range []string{"status", "message", "data", "timestamp", "window", "units", "getters"} {
+		if _, exist := v2[key]; !exist {
+			t.Errorf("Missing field %v: %v", key, string(ebytes))
+		}
+	}
+	if units, ok := v2["units"].(map[string]interface{}); !ok || len(units) != 1 || units[Latency] != "ms" {
+		t.Errorf("Wrong units: %v", v2["units"])
+	}
+	if v2["timestamp"].(float64) == 0 {
+		t.Errorf("Timestamp is not set: %v", string(ebytes))
+	}
+}
+
+func TestNewRangeResponseV2(t *testing.T) {
+	es := NewEntitySeries("aid1", ApplicationType)
+	es.AddSample(Latency, 1524246000, 133.2)
+
+	res := NewRangeResponse()
+	res.SetStatus(StatusSuccess, "Success")
+	res.Data = []*EntitySeries{es}
+
+	ebytes, err := json.Marshal(NewRangeResponseV2(res))
+	if err != nil {
+		t.Errorf("Failed to marshall RangeResponseV2 %+v", res)
+		return
+	}
+
+	var v2 map[string]interface{}
+	if err = json.Unmarshal(ebytes, &v2); err != nil {
+		t.Errorf("Failed to un-marshal bytes: %v", string(ebytes))
+		return
+	}
+
+	for _, key := range []string{"status", "message", "data", "start", "end", "stepSeconds", "units", "getters"} {
+		if _, exist := v2[key]; !exist {
+			t.Errorf("Missing field %v: %v", key, string(ebytes))
+		}
+	}
+	if units, ok := v2["units"].(map[string]interface{}); !ok || len(units) != 1 || units[Latency] != "ms" {
+		t.Errorf("Wrong units: %v", v2["units"])
+	}
+}
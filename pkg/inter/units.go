@@ -0,0 +1,106 @@
+package inter
+
+import (
+	"strings"
+)
+
+// Units of the metrics
+const (
+	UnitPerSecond   = "1/s"
+	UnitMillisecond = "ms"
+	UnitSecond      = "s"
+	UnitByte        = "bytes"
+	UnitRatio       = "ratio"
+	UnitCount       = "count"
+)
+
+// units of the metrics by name
+var metricUnits = map[string]string{
+	TPS:          UnitPerSecond,
+	Latency:      UnitMillisecond,
+	RequestRate:  UnitPerSecond,
+	SuccessRate:  UnitPerSecond,
+	Error4xxRate: UnitPerSecond,
+	Error5xxRate: UnitPerSecond,
+	ErrorRatio:   UnitRatio,
+
+	// Redis
+	"connected_clients": UnitCount,
+}
+
+// units of the metrics by name prefix
+var metricUnitPrefixes = map[string]string{
+	LatencyPercentilePrefix: UnitMillisecond,
+
+	// Redis
+	"command_rate_": UnitPerSecond,
+}
+
+// units of the metrics by name suffix, for the ones following the Prometheus naming conventions
+var metricUnitSuffixes = map[string]string{
+	"_bytes":   UnitByte,
+	"_seconds": UnitSecond,
+	"_ratio":   UnitRatio,
+	"_rate":    UnitPerSecond,
+}
+
+// MetricUnit returns the unit of the metric, such as "ms" for "latency"; empty if unknown.
+// The category prefix of a conflicting metric, such as "Istio.tps", is ignored.
+func MetricUnit(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	if unit, exist := metricUnits[name]; exist {
+		return unit
+	}
+
+	// the longest match wins
+	unit, matched := "", 0
+	for prefix, u := range metricUnitPrefixes {
+		if strings.HasPrefix(name, prefix) && len(prefix) > matched {
+			unit, matched = u, len(prefix)
+		}
+	}
+	if matched > 0 {
+		return unit
+	}
+
+	for suffix, u := range metricUnitSuffixes {
+		if strings.HasSuffix(name, suffix) && len(suffix) > matched {
+			unit, matched = u, len(suffix)
+		}
+	}
+	return unit
+}
+
+// MetricUnits returns the units of all the metrics of the entities; the metrics of unknown units are skipped.
+func MetricUnits(entities []*EntityMetric) map[string]string {
+	result := make(map[string]string)
+	for _, e := range entities {
+		for name := range e.Metrics {
+			addMetricUnit(result, name)
+		}
+	}
+	return result
+}
+
+// SeriesUnits returns the units of all the metrics of the series; the metrics of unknown units are skipped.
+func SeriesUnits(series []*EntitySeries) map[string]string {
+	result := make(map[string]string)
+	for _, s := range series {
+		for name := range s.Metrics {
+			addMetricUnit(result, name)
+		}
+	}
+	return result
+}
+
+func addMetricUnit(units map[string]string, name string) {
+	if _, exist := units[name]; exist {
+		return
+	}
+	if unit := MetricUnit(name); len(unit) > 0 {
+		units[name] = unit
+	}
+}
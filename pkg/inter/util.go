@@ -1,5 +1,17 @@
 package inter
 
+import (
+	"strconv"
+	"strings"
+)
+
+// LatencyPercentile returns the metric name of a latency percentile,
+// for example, "latency_p99" for 99, and "latency_p99_9" for 99.9
+func LatencyPercentile(p float64) string {
+	v := strconv.FormatFloat(p, 'f', -1, 64)
+	return LatencyPercentilePrefix + strings.Replace(v, ".", "_", -1)
+}
+
 func GenerateFakeMetrics() []*EntityMetric {
 	result := []*EntityMetric{}
 
@@ -0,0 +1,90 @@
+package inter
+
+import (
+	"time"
+)
+
+// MetricResponseV2 : the response of the v2 API.
+// Unlike MetricResponse, the fields are always present, and named as documented.
+type MetricResponseV2 struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Data    []*EntityMetric `json:"data"`
+
+	// the (unix) time of the metrics: when they were scraped, or queried at
+	Timestamp int64 `json:"timestamp"`
+	// only set when served from a cached snapshot
+	Age float64 `json:"ageSeconds,omitempty"`
+	// only set when the rate window is overridden by the request; the window of each getter is in its status
+	Window string `json:"window,omitempty"`
+
+	// the unit of each metric in data, such as "latency": "ms"; the metrics of unknown units are not listed
+	Units   map[string]string `json:"units"`
+	Getters []*GetterStatus   `json:"getters"`
+}
+
+// NewMetricResponseV2 converts the response into v2; the timestamp is now if it is not set.
+func NewMetricResponseV2(r *MetricResponse) *MetricResponseV2 {
+	resp := &MetricResponseV2{
+		Status:    r.Status,
+		Message:   r.Message,
+		Data:      r.Data,
+		Timestamp: r.Timestamp,
+		Age:       r.Age,
+		Window:    r.Window,
+		Units:     MetricUnits(r.Data),
+		Getters:   r.Getters,
+	}
+
+	if resp.Data == nil {
+		resp.Data = []*EntityMetric{}
+	}
+	if resp.Getters == nil {
+		resp.Getters = []*GetterStatus{}
+	}
+	if resp.Timestamp == 0 {
+		resp.Timestamp = time.Now().Unix()
+	}
+	return resp
+}
+
+// RangeResponseV2 : the response of the v2 range API.
+// Unlike RangeResponse, the fields are always present, except window as in MetricResponseV2.
+type RangeResponseV2 struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Data    []*EntitySeries `json:"data"`
+
+	// the range: unix timestamps, and the step in seconds
+	Start  int64   `json:"start"`
+	End    int64   `json:"end"`
+	Step   float64 `json:"stepSeconds"`
+	Window string  `json:"window,omitempty"`
+
+	// the unit of each metric in data, such as "latency": "ms"; the metrics of unknown units are not listed
+	Units   map[string]string `json:"units"`
+	Getters []*GetterStatus   `json:"getters"`
+}
+
+// NewRangeResponseV2 converts the range response into v2
+func NewRangeResponseV2(r *RangeResponse) *RangeResponseV2 {
+	resp := &RangeResponseV2{
+		Status:  r.Status,
+		Message: r.Message,
+		Data:    r.Data,
+		Start:   r.Start,
+		End:     r.End,
+		Step:    r.Step,
+		Window:  r.Window,
+		Units:   SeriesUnits(r.Data),
+		Getters: r.Getters,
+	}
+
+	if resp.Data == nil {
+		resp.Data = []*EntitySeries{}
+	}
+	if resp.Getters == nil {
+		resp.Getters = []*GetterStatus{}
+	}
+	return resp
+}
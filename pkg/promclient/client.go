@@ -0,0 +1,356 @@
+package promclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/common/model"
+	xfire "github.com/songbinliu/xfire/pkg/prometheus"
+
+	"appMetric/pkg/selfmetric"
+)
+
+const (
+	apiQueryPath = "/api/v1/query"
+	apiRangePath = "/api/v1/query_range"
+	// the values of a label, such as "/api/v1/label/job/values"
+	apiLabelValuesPath = "/api/v1/label/%s/values"
+
+	defaultTimeOut = time.Duration(60 * time.Second)
+)
+
+// MetricClient : gets the instant vector of a query, and parses it with the RequestInput.
+// The entity getters query Prometheus through it.
+type MetricClient interface {
+	GetMetrics(input xfire.RequestInput) ([]xfire.MetricData, error)
+}
+
+// RestClient : a Prometheus HTTP API client supporting both instant and range queries
+type RestClient struct {
+	client   *http.Client
+	host     string
+	username string
+	password string
+
+	headers    map[string]string
+	token      *tokenSource
+	maxRetries int
+	options    ClientOptions
+
+	// the context of the requests; nil for context.Background()
+	ctx context.Context
+}
+
+// ensure RestClient implement the requisite interfaces
+var _ MetricClient = &RestClient{}
+
+// the response of the Prometheus HTTP API
+type apiResponse struct {
+	Status    string   `json:"status"`
+	Data      *rawData `json:"data,omitempty"`
+	ErrorType string   `json:"errorType,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type rawData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// RawSeries : one series of a range query (the "matrix" result)
+type RawSeries struct {
+	Labels map[string]string  `json:"metric"`
+	Values []model.SamplePair `json:"values"`
+}
+
+// NewRestClient creates a client for the Prometheus server at host, such as "http://localhost:9090"
+func NewRestClient(host string) (*RestClient, error) {
+	return NewRestClientWithOptions(host, nil)
+}
+
+// NewRestClientWithOptions creates a client with the TLS, auth, header and retry settings; nil options for the plain client.
+// The server certificate of https is verified unless opts.InsecureSkipVerify is set.
+func NewRestClientWithOptions(host string, opts *ClientOptions) (*RestClient, error) {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	//1. get http client
+	client := &http.Client{
+		Timeout: defaultTimeOut,
+	}
+	if opts.Timeout > 0 {
+		client.Timeout = opts.Timeout
+	}
+
+	//2. check whether it is using ssl
+	if !strings.HasPrefix(host, "http") {
+		host = "http://" + host
+	}
+
+	addr, err := url.Parse(host)
+	if err != nil {
+		glog.Errorf("Invalid url:%v, %v", host, err)
+		return nil, err
+	}
+	if addr.Scheme == "https" {
+		tlsConfig, err := opts.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}
+	}
+
+	glog.V(2).Infof("Prometheus server address is: %v", host)
+	result := &RestClient{
+		client:     client,
+		host:       strings.TrimSuffix(host, "/"),
+		username:   opts.Username,
+		password:   opts.Password,
+		headers:    opts.Headers,
+		maxRetries: opts.MaxRetries,
+		options:    *opts,
+	}
+	if len(opts.BearerTokenFile) > 0 {
+		result.token = &tokenSource{fname: opts.BearerTokenFile}
+	}
+	return result, nil
+}
+
+// SetUser sets the login user/password for the prometheus client
+func (c *RestClient) SetUser(username, password string) {
+	c.username = username
+	c.password = password
+}
+
+// WithContext returns a shallow copy of the client, sending its requests under ctx:
+// they are cancelled, and the retries stop, once ctx is done.
+func (c *RestClient) WithContext(ctx context.Context) *RestClient {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+func (c *RestClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Host returns the address of the Prometheus server
+func (c *RestClient) Host() string {
+	return c.host
+}
+
+// GetMetrics runs an instant query, and parses each sample of the vector result with input.
+// Samples failed to parse are skipped.
+func (c *RestClient) GetMetrics(input xfire.RequestInput) ([]xfire.MetricData, error) {
+	return c.getMetrics(input, time.Time{})
+}
+
+// At returns a MetricClient which evaluates the instant queries at time t
+func (c *RestClient) At(t time.Time) MetricClient {
+	return &timeClient{client: c, t: t}
+}
+
+// getMetrics runs an instant query at time t; the current time of the server if t is zero.
+func (c *RestClient) getMetrics(input xfire.RequestInput, t time.Time) ([]xfire.MetricData, error) {
+	result := []xfire.MetricData{}
+
+	params := url.Values{}
+	params.Set("query", input.GetQuery())
+	if !t.IsZero() {
+		params.Set("time", model.TimeFromUnixNano(t.UnixNano()).String())
+	}
+	dat, err := c.query(apiQueryPath, params, "vector")
+	if err != nil {
+		return result, err
+	}
+
+	var metrics []xfire.RawMetric
+	if err := json.Unmarshal(dat.Result, &metrics); err != nil {
+		return result, fmt.Errorf("Failed to unmarshal vector: %v", err)
+	}
+
+	for i := range metrics {
+		d, err := input.Parse(&metrics[i])
+		if err != nil {
+			glog.V(3).Infof("Failed to parse metric %v: %v", metrics[i].Labels, err)
+			continue
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// timeClient : the MetricClient evaluating the instant queries at a time
+type timeClient struct {
+	client *RestClient
+	t      time.Time
+}
+
+func (c *timeClient) GetMetrics(input xfire.RequestInput) ([]xfire.MetricData, error) {
+	return c.client.getMetrics(input, c.t)
+}
+
+// QueryRange runs a range query, and returns the series of the matrix result
+func (c *RestClient) QueryRange(query string, r *Range) ([]*RawSeries, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", model.TimeFromUnix(r.Start.Unix()).String())
+	params.Set("end", model.TimeFromUnix(r.End.Unix()).String())
+	params.Set("step", fmt.Sprintf("%d", int64(r.Step/time.Second)))
+
+	dat, err := c.query(apiRangePath, params, "matrix")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*RawSeries
+	if err := json.Unmarshal(dat.Result, &result); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal matrix: %v", err)
+	}
+	return result, nil
+}
+
+// query sends the request to the API path, and checks the type of the result
+func (c *RestClient) query(path string, params url.Values, resultType string) (*rawData, error) {
+	if len(strings.TrimSpace(params.Get("query"))) < 1 {
+		return nil, fmt.Errorf("Prometheus query is empty")
+	}
+
+	content, status, err := c.get(c.context(), path, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var ss apiResponse
+	if err := json.Unmarshal(content, &ss); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal response (%v): %v", status, err)
+	}
+
+	if ss.Status != "success" {
+		return nil, fmt.Errorf("%v: %v", ss.ErrorType, ss.Error)
+	}
+	if ss.Data == nil {
+		return nil, fmt.Errorf("No data in response")
+	}
+	if ss.Data.ResultType != resultType {
+		return nil, fmt.Errorf("Unsupported result type: %v, expecting %v", ss.Data.ResultType, resultType)
+	}
+
+	return ss.Data, nil
+}
+
+// GetLabelValues returns the values of the label, such as the jobs of "job"
+func (c *RestClient) GetLabelValues(label string) ([]string, error) {
+	content, status, err := c.get(c.context(), fmt.Sprintf(apiLabelValuesPath, url.PathEscape(label)), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	var ss struct {
+		Status string   `json:"status"`
+		Data   []string `json:"data"`
+		Error  string   `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(content, &ss); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal response (%v): %v", status, err)
+	}
+	if ss.Status != "success" {
+		return nil, fmt.Errorf("Failed to get values of label %v: %v", label, ss.Error)
+	}
+	return ss.Data, nil
+}
+
+// GetJobs returns the jobs in the Prometheus server
+func (c *RestClient) GetJobs() ([]string, error) {
+	return c.GetLabelValues("job")
+}
+
+// get sends the request to the API path, and retries with exponential backoff on network errors, 429 and 5xx.
+// It returns the content and the status of the last response; the retries stop once ctx is done.
+func (c *RestClient) get(ctx context.Context, path string, params url.Values) ([]byte, string, error) {
+	for attempt := 0; ; attempt++ {
+		content, code, status, err := c.send(ctx, path, params)
+		if (err == nil && !retryable(code)) || attempt >= c.maxRetries {
+			if err != nil && attempt > 0 {
+				err = fmt.Errorf("%v (after %d retries)", err, attempt)
+			}
+			return content, status, err
+		}
+
+		wait := c.options.backoff(attempt)
+		if err == nil {
+			err = fmt.Errorf("%v", status)
+		}
+		glog.V(2).Infof("Retry request to %v%v in %v (%d/%d): %v", c.host, path, wait, attempt+1, c.maxRetries, err)
+		selfmetric.PrometheusRetries.Inc(c.host)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, "", fmt.Errorf("%v (retry aborted after %d retries: %v)", err, attempt, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable returns true for the status codes of transient failures
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// send sends one request under ctx, with the headers and the auth; it returns the content, status code and status
+func (c *RestClient) send(ctx context.Context, path string, params url.Values) ([]byte, int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.host+path, nil)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("Failed to generate a http.request: %v", err)
+	}
+	req.URL.RawQuery = params.Encode()
+	glog.V(4).Infof("path=%v, params=%v", path, params)
+
+	req.Header.Set("Accept", "application/json")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	switch {
+	case c.token != nil:
+		token, err := c.token.get()
+		if err != nil {
+			return nil, 0, "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case len(c.username) > 0:
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("Failed to send http request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, resp.Status, fmt.Errorf("Failed to read response: %v", err)
+	}
+	glog.V(4).Infof("resp: %v", string(content))
+
+	return content, resp.StatusCode, resp.Status, nil
+}
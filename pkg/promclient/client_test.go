@@ -0,0 +1,218 @@
+package promclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xfire "github.com/songbinliu/xfire/pkg/prometheus"
+)
+
+// a stand-in Prometheus server: query -> vector result for instant queries, and matrix result for range queries
+type fakePrometheus struct {
+	*httptest.Server
+	vectors  map[string]string
+	matrices map[string]string
+
+	// number of the range queries received
+	rangeNum int32
+	// parameters of the last range query, and the time of the last instant query
+	lastRange string
+	lastTime  string
+}
+
+func newFakePrometheus(vectors, matrices map[string]string) *fakePrometheus {
+	p := &fakePrometheus{vectors: vectors, matrices: matrices}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		results, rtype := p.vectors, "vector"
+		p.lastTime = r.URL.Query().Get("time")
+		if r.URL.Path == apiRangePath {
+			atomic.AddInt32(&p.rangeNum, 1)
+			p.lastRange = fmt.Sprintf("%v,%v,%v", r.URL.Query().Get("start"), r.URL.Query().Get("end"), r.URL.Query().Get("step"))
+			results, rtype = p.matrices, "matrix"
+		}
+
+		result, ok := results[query]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"status":"error","errorType":"bad_data","error":"unknown query: %v"}`, query)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"%v","result":[%v]}}`, rtype, result)
+	}))
+	return p
+}
+
+func TestRestClient_GetMetrics(t *testing.T) {
+	server := newFakePrometheus(map[string]string{
+		"up": `{"metric":{"instance":"a"},"value":[1524246000,"1"]},{"metric":{"instance":"b"},"value":[1524246000,"NaN"]}`,
+	}, nil)
+	defer server.Close()
+
+	client, err := NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	input := xfire.NewBasicInput()
+	input.SetQuery("up")
+	dat, err := client.GetMetrics(input)
+	if err != nil {
+		t.Errorf("Failed to get metrics: %v", err)
+		return
+	}
+
+	// NaN is skipped by the parser
+	if len(dat) != 1 || dat[0].GetValue() != 1 || dat[0].(*xfire.BasicMetricData).Labels["instance"] != "a" {
+		t.Errorf("Wrong metrics: %+v", dat)
+	}
+
+	input.SetQuery("down")
+	if _, err := client.GetMetrics(input); err == nil {
+		t.Errorf("Unknown query should fail")
+	}
+}
+
+func TestRestClient_At(t *testing.T) {
+	server := newFakePrometheus(map[string]string{
+		"up": `{"metric":{"instance":"a"},"value":[1524246000,"1"]}`,
+	}, nil)
+	defer server.Close()
+
+	client, err := NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	input := xfire.NewBasicInput()
+	input.SetQuery("up")
+	if _, err := client.At(time.Unix(1524246000, 500000000)).GetMetrics(input); err != nil {
+		t.Errorf("Failed to get metrics: %v", err)
+		return
+	}
+	if server.lastTime != "1524246000.5" {
+		t.Errorf("Wrong time parameter: %v", server.lastTime)
+	}
+
+	// no time parameter for the current time
+	if _, err := client.GetMetrics(input); err != nil || server.lastTime != "" {
+		t.Errorf("Unexpected time parameter: %v, %v", server.lastTime, err)
+	}
+}
+
+func TestRestClient_QueryRange(t *testing.T) {
+	server := newFakePrometheus(nil, map[string]string{
+		"up": `{"metric":{"instance":"a"},"values":[[1524246000,"1"],[1524246060,"0"]]}`,
+	})
+	defer server.Close()
+
+	client, err := NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	r, err := NewRange(time.Unix(1524246000, 0), time.Unix(1524246060, 0), time.Minute)
+	if err != nil {
+		t.Errorf("Failed to create range: %v", err)
+		return
+	}
+
+	series, err := client.QueryRange("up", r)
+	if err != nil {
+		t.Errorf("Failed to query range: %v", err)
+		return
+	}
+
+	if server.lastRange != "1524246000,1524246060,60" {
+		t.Errorf("Wrong range parameters: %v", server.lastRange)
+	}
+	if len(series) != 1 || len(series[0].Values) != 2 || series[0].Labels["instance"] != "a" || series[0].Values[1].Value != 0 {
+		t.Errorf("Wrong series: %+v", series)
+	}
+
+	// an instant query is not a range query
+	input := xfire.NewBasicInput()
+	input.SetQuery("up")
+	if _, err := client.GetMetrics(input); err == nil {
+		t.Errorf("Query without vector result should fail")
+	}
+}
+
+func TestNewRange(t *testing.T) {
+	start := time.Unix(1524246000, 0)
+	tests := []struct {
+		end   time.Time
+		step  time.Duration
+		valid bool
+		num   int
+	}{
+		{start.Add(time.Hour), time.Minute, true, 61},
+		{start, time.Minute, true, 1},
+		{start.Add(-time.Minute), time.Minute, false, 0},
+		{start.Add(time.Hour), 0, false, 0},
+		{start.Add(time.Hour), 1500 * time.Millisecond, false, 0},
+		{start.Add(24 * time.Hour), time.Second, false, 0},
+	}
+
+	for _, test := range tests {
+		r, err := NewRange(start, test.end, test.step)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("Range [%v, %v] by %v should be invalid", start, test.end, test.step)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to create range: %v", err)
+			continue
+		}
+		if ts := r.Timestamps(); len(ts) != test.num || !ts[0].Equal(start) {
+			t.Errorf("Wrong timestamps: %v", ts)
+		}
+	}
+}
+
+func TestRangeClient_At(t *testing.T) {
+	server := newFakePrometheus(nil, map[string]string{
+		"up": `{"metric":{"instance":"a"},"values":[[1524246000,"1"],[1524246060,"0"]]},
+			{"metric":{"instance":"b"},"values":[[1524246060,"1"]]}`,
+	})
+	defer server.Close()
+
+	client, err := NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	r, _ := NewRange(time.Unix(1524246000, 0), time.Unix(1524246060, 0), time.Minute)
+	rclient := NewRangeClient(client, r)
+
+	input := xfire.NewBasicInput()
+	input.SetQuery("up")
+	expects := []int{1, 2}
+	for i, ts := range r.Timestamps() {
+		dat, err := rclient.At(ts).GetMetrics(input)
+		if err != nil || len(dat) != expects[i] {
+			t.Errorf("Wrong metrics at %v: %v, %+v", ts, err, dat)
+		}
+	}
+
+	// the range query is sent only once
+	if server.rangeNum != 1 {
+		t.Errorf("Expected 1 range query, got %d", server.rangeNum)
+	}
+
+	input.SetQuery("down")
+	if _, err := rclient.At(r.Start).GetMetrics(input); err == nil {
+		t.Errorf("Unknown query should fail")
+	}
+}
@@ -0,0 +1,155 @@
+package promclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// the backoff of the first retry; doubled for each retry after it, up to maxRetryBackoff
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff     = 10 * time.Second
+
+	// the bearer token file is read again after this period, to follow the rotated tokens
+	tokenRefreshPeriod = time.Minute
+)
+
+// ClientOptions : the connection settings of a Prometheus client; the zero value is a plain client without retry.
+type ClientOptions struct {
+	// the CA bundle to verify the server certificate; the system roots if empty
+	CAFile string
+	// the client certificate and key, for mutual TLS
+	CertFile string
+	KeyFile  string
+	// skip the verification of the server certificate; only for testing
+	InsecureSkipVerify bool
+
+	// basic auth, ignored if there is a bearer token
+	Username string
+	Password string
+	// the file of the bearer token, such as the in-cluster service account token
+	// "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	BearerTokenFile string
+
+	// the headers of each request, such as "X-Scope-OrgID" of the multi-tenant backends
+	Headers map[string]string
+
+	// the number of retries of a failed request (network error, 429 or 5xx); 0 for no retry
+	MaxRetries int
+	// the backoff before the first retry, doubled for each retry after it; default is 500ms
+	RetryBackoff time.Duration
+
+	// the timeout of each request; default is 60s
+	Timeout time.Duration
+}
+
+// Validate checks the options, including the files
+func (o *ClientOptions) Validate() error {
+	if (len(o.CertFile) > 0) != (len(o.KeyFile) > 0) {
+		return fmt.Errorf("certFile and keyFile should be set together")
+	}
+	if o.MaxRetries < 0 {
+		return fmt.Errorf("maxRetries should not be negative")
+	}
+	if o.RetryBackoff < 0 || o.Timeout < 0 {
+		return fmt.Errorf("retryBackoff and timeout should not be negative")
+	}
+
+	if _, err := o.tlsConfig(); err != nil {
+		return err
+	}
+	if len(o.BearerTokenFile) > 0 {
+		if _, err := readToken(o.BearerTokenFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tlsConfig builds the TLS config of the https connections
+func (o *ClientOptions) tlsConfig() (*tls.Config, error) {
+	conf := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+
+	if len(o.CAFile) > 0 {
+		content, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found in CA file %v", o.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	if len(o.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
+
+// backoff returns the wait before the retry, for the attempt (0 for the first retry)
+func (o *ClientOptions) backoff(attempt int) time.Duration {
+	result := o.RetryBackoff
+	if result <= 0 {
+		result = defaultRetryBackoff
+	}
+	for i := 0; i < attempt && result < maxRetryBackoff; i++ {
+		result *= 2
+	}
+	if result > maxRetryBackoff {
+		result = maxRetryBackoff
+	}
+	return result
+}
+
+func readToken(fname string) (string, error) {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bearer token file: %v", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if len(token) < 1 {
+		return "", fmt.Errorf("bearer token file %v is empty", fname)
+	}
+	return token, nil
+}
+
+// tokenSource : reads the bearer token from the file, and reads it again periodically
+type tokenSource struct {
+	fname string
+
+	lock     sync.Mutex
+	token    string
+	loadTime time.Time
+}
+
+func (s *tokenSource) get() (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.token) > 0 && time.Since(s.loadTime) < tokenRefreshPeriod {
+		return s.token, nil
+	}
+
+	token, err := readToken(s.fname)
+	if err != nil {
+		// keep the last token if the file is being rotated
+		if len(s.token) > 0 {
+			return s.token, nil
+		}
+		return "", err
+	}
+	s.token, s.loadTime = token, time.Now()
+	return token, nil
+}
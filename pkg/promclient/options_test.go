@@ -0,0 +1,295 @@
+package promclient
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xfire "github.com/songbinliu/xfire/pkg/prometheus"
+
+	"appMetric/pkg/selfmetric"
+)
+
+const upResult = `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"instance":"a"},"value":[1524246000,"1"]}]}}`
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "promclient")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	return f.Name()
+}
+
+func getUp(client *RestClient) error {
+	input := xfire.NewBasicInput()
+	input.SetQuery("up")
+	dat, err := client.GetMetrics(input)
+	if err != nil {
+		return err
+	}
+	if len(dat) != 1 {
+		return fmt.Errorf("Wrong metrics: %v", dat)
+	}
+	return nil
+}
+
+func TestRestClient_Retry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			fmt.Fprint(w, upResult)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewRestClientWithOptions(server.URL, &ClientOptions{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	retries := selfmetric.PrometheusRetries.Get(client.host)
+	if err := getUp(client); err != nil {
+		t.Errorf("Failed after retries: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Expected 3 requests, got %d", n)
+	}
+	if n := selfmetric.PrometheusRetries.Get(client.host) - retries; n != 2 {
+		t.Errorf("Expected 2 retries counted, got %v", n)
+	}
+
+	//2. give up after maxRetries
+	atomic.StoreInt32(&calls, 0)
+	client.maxRetries = 1
+	if err := getUp(client); err == nil {
+		t.Errorf("Expected failure after 1 retry")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected 2 requests, got %d", n)
+	}
+}
+
+func TestRestClient_RetryCancelled(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := NewRestClientWithOptions(server.URL, &ClientOptions{MaxRetries: 3, RetryBackoff: time.Minute})
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+
+	// the backoff is interrupted by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := getUp(client.WithContext(ctx)); err == nil {
+		t.Errorf("Expected error of cancelled retries")
+	}
+	if du := time.Since(start); du > 10*time.Second {
+		t.Errorf("Retries should stop with the context, took %v", du)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+
+	// a cancelled context sends no request
+	atomic.StoreInt32(&calls, 0)
+	if err := getUp(client.WithContext(ctx)); err == nil {
+		t.Errorf("Expected error of cancelled context")
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("Expected no request, got %d", n)
+	}
+}
+
+func TestRestClient_NoRetryOnBadRequest(t *testing.T) {
+	server := newFakePrometheus(map[string]string{}, nil)
+	defer server.Close()
+
+	var calls int32
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		handler.ServeHTTP(w, r)
+	})
+
+	client, err := NewRestClientWithOptions(server.URL, &ClientOptions{MaxRetries: 3, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+	if err := getUp(client); err == nil {
+		t.Errorf("Expected error of unknown query")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("4xx should not be retried, got %d requests", n)
+	}
+}
+
+func TestRestClient_Auth(t *testing.T) {
+	var auth, org atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		org.Store(r.Header.Get("X-Scope-OrgID"))
+		fmt.Fprint(w, upResult)
+	}))
+	defer server.Close()
+
+	fname := writeTempFile(t, "token-1\n")
+	defer os.Remove(fname)
+
+	tests := []struct {
+		opts     *ClientOptions
+		expected string
+	}{
+		{&ClientOptions{Username: "user", Password: "pass"}, "Basic dXNlcjpwYXNz"},
+		{&ClientOptions{Username: "user", Password: "pass", BearerTokenFile: fname}, "Bearer token-1"},
+	}
+	for _, test := range tests {
+		test.opts.Headers = map[string]string{"X-Scope-OrgID": "tenant-1"}
+		client, err := NewRestClientWithOptions(server.URL, test.opts)
+		if err != nil {
+			t.Errorf("Failed to create client: %v", err)
+			continue
+		}
+		if err := getUp(client); err != nil {
+			t.Errorf("Failed to get metrics: %v", err)
+			continue
+		}
+		if v := auth.Load(); v != test.expected {
+			t.Errorf("Wrong Authorization: %v, expected %v", v, test.expected)
+		}
+		if v := org.Load(); v != "tenant-1" {
+			t.Errorf("Wrong header X-Scope-OrgID: %v", v)
+		}
+	}
+
+	if _, err := NewRestClientWithOptions(server.URL, &ClientOptions{BearerTokenFile: fname + ".missing"}); err == nil {
+		t.Errorf("Expected error of missing token file")
+	}
+}
+
+func TestTokenSource_Refresh(t *testing.T) {
+	fname := writeTempFile(t, "token-1")
+	defer os.Remove(fname)
+
+	s := &tokenSource{fname: fname}
+	if token, err := s.get(); err != nil || token != "token-1" {
+		t.Errorf("Wrong token: %v, %v", token, err)
+	}
+
+	// the rotated token is read after the refresh period
+	ioutil.WriteFile(fname, []byte("token-2"), 0600)
+	if token, _ := s.get(); token != "token-1" {
+		t.Errorf("Token should be cached: %v", token)
+	}
+	s.loadTime = s.loadTime.Add(-tokenRefreshPeriod)
+	if token, _ := s.get(); token != "token-2" {
+		t.Errorf("Token should be refreshed: %v", token)
+	}
+
+	// the last token is kept if the file is missing
+	os.Remove(fname)
+	s.loadTime = s.loadTime.Add(-tokenRefreshPeriod)
+	if token, err := s.get(); err != nil || token != "token-2" {
+		t.Errorf("Last token should be kept: %v, %v", token, err)
+	}
+}
+
+func TestRestClient_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, upResult)
+	}))
+	defer server.Close()
+
+	//1. the certificate is verified by default
+	client, err := NewRestClient(server.URL)
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+	if err := getUp(client); err == nil {
+		t.Errorf("Expected error of unknown certificate authority")
+	}
+
+	//2. trusted by the CA file
+	ca := writeTempFile(t, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	defer os.Remove(ca)
+	client, err = NewRestClientWithOptions(server.URL, &ClientOptions{CAFile: ca})
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+	if err := getUp(client); err != nil {
+		t.Errorf("Failed with CA file: %v", err)
+	}
+
+	//3. skip the verification
+	client, err = NewRestClientWithOptions(server.URL, &ClientOptions{InsecureSkipVerify: true})
+	if err != nil {
+		t.Errorf("Failed to create client: %v", err)
+		return
+	}
+	if err := getUp(client); err != nil {
+		t.Errorf("Failed with InsecureSkipVerify: %v", err)
+	}
+}
+
+func TestClientOptions_Validate(t *testing.T) {
+	empty := writeTempFile(t, "")
+	defer os.Remove(empty)
+
+	tests := []struct {
+		opts  ClientOptions
+		valid bool
+	}{
+		{ClientOptions{}, true},
+		{ClientOptions{MaxRetries: 3, RetryBackoff: time.Second}, true},
+		{ClientOptions{CertFile: "client.crt"}, false},
+		{ClientOptions{MaxRetries: -1}, false},
+		{ClientOptions{Timeout: -time.Second}, false},
+		{ClientOptions{CAFile: empty}, false},
+		{ClientOptions{BearerTokenFile: empty}, false},
+	}
+	for i, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("test[%d]: expected valid=%v, got %v", i, test.valid, err)
+		}
+	}
+}
+
+func TestClientOptions_Backoff(t *testing.T) {
+	o := &ClientOptions{RetryBackoff: time.Second}
+	expects := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, maxRetryBackoff, maxRetryBackoff}
+	for i, e := range expects {
+		if b := o.backoff(i); b != e {
+			t.Errorf("attempt %d: expected %v, got %v", i, e, b)
+		}
+	}
+
+	if b := (&ClientOptions{}).backoff(0); b != defaultRetryBackoff {
+		t.Errorf("Expected default backoff, got %v", b)
+	}
+}
@@ -0,0 +1,152 @@
+package promclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/common/model"
+	xfire "github.com/songbinliu/xfire/pkg/prometheus"
+)
+
+const (
+	// Prometheus rejects range queries resulting in more points per series
+	MaxRangePoints = 11000
+)
+
+// Range : the time range of a range query, evaluated at Start, Start+Step, ... until End
+type Range struct {
+	Start time.Time
+	End   time.Time
+	Step  time.Duration
+}
+
+// NewRange creates a Range; start and end are truncated to seconds, and step should be whole seconds.
+func NewRange(start, end time.Time, step time.Duration) (*Range, error) {
+	r := &Range{
+		Start: start.Truncate(time.Second),
+		End:   end.Truncate(time.Second),
+		Step:  step,
+	}
+
+	if step < time.Second || step%time.Second != 0 {
+		return nil, fmt.Errorf("invalid step: %v, should be whole seconds", step)
+	}
+	if r.End.Before(r.Start) {
+		return nil, fmt.Errorf("end %v is before start %v", r.End, r.Start)
+	}
+	if n := r.End.Sub(r.Start)/step + 1; n > MaxRangePoints {
+		return nil, fmt.Errorf("too many points: %d, should be no more than %d; increase the step", n, MaxRangePoints)
+	}
+
+	return r, nil
+}
+
+// Timestamps returns the times the queries are evaluated at
+func (r *Range) Timestamps() []time.Time {
+	result := []time.Time{}
+	for t := r.Start; !t.After(r.End); t = t.Add(r.Step) {
+		result = append(result, t)
+	}
+	return result
+}
+
+// RangeClient : runs each query as a range query once, and serves its samples
+// at each timestamp of the range as instant vectors (see At).
+// So the getters can get the metrics over a range, without knowing range queries.
+type RangeClient struct {
+	client *RestClient
+	r      *Range
+
+	lock    sync.Mutex
+	results map[string]*rangeResult
+}
+
+// the samples of one range query, grouped by timestamp
+type rangeResult struct {
+	once    sync.Once
+	samples map[model.Time][]xfire.RawMetric
+	err     error
+}
+
+func NewRangeClient(client *RestClient, r *Range) *RangeClient {
+	return &RangeClient{
+		client:  client,
+		r:       r,
+		results: make(map[string]*rangeResult),
+	}
+}
+
+// At returns a MetricClient which gets the instant vectors at timestamp t of the range
+func (c *RangeClient) At(t time.Time) MetricClient {
+	return &pointClient{
+		rclient: c,
+		t:       model.TimeFromUnixNano(t.UnixNano()),
+	}
+}
+
+// get runs the range query for the first call, and returns the cached result for the later ones
+func (c *RangeClient) get(query string) (map[model.Time][]xfire.RawMetric, error) {
+	c.lock.Lock()
+	res, exist := c.results[query]
+	if !exist {
+		res = &rangeResult{}
+		c.results[query] = res
+	}
+	c.lock.Unlock()
+
+	res.once.Do(func() {
+		if c.client == nil {
+			res.err = fmt.Errorf("no Prometheus client")
+			return
+		}
+
+		series, err := c.client.QueryRange(query, c.r)
+		if err != nil {
+			res.err = err
+			return
+		}
+
+		res.samples = make(map[model.Time][]xfire.RawMetric)
+		for _, s := range series {
+			for _, v := range s.Values {
+				res.samples[v.Timestamp] = append(res.samples[v.Timestamp], xfire.RawMetric{Labels: s.Labels, Value: v})
+			}
+		}
+		glog.V(4).Infof("Range query %v: %d series", query, len(series))
+	})
+
+	return res.samples, res.err
+}
+
+// pointClient : the MetricClient of one timestamp of a RangeClient
+type pointClient struct {
+	rclient *RangeClient
+	t       model.Time
+}
+
+func (c *pointClient) GetMetrics(input xfire.RequestInput) ([]xfire.MetricData, error) {
+	result := []xfire.MetricData{}
+
+	samples, err := c.rclient.get(input.GetQuery())
+	if err != nil {
+		return result, err
+	}
+
+	for _, m := range samples[c.t] {
+		// the labels may be modified by Parse
+		raw := xfire.RawMetric{Labels: make(map[string]string), Value: m.Value}
+		for k, v := range m.Labels {
+			raw.Labels[k] = v
+		}
+
+		d, err := input.Parse(&raw)
+		if err != nil {
+			glog.V(3).Infof("Failed to parse metric %v: %v", raw.Labels, err)
+			continue
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
@@ -0,0 +1,253 @@
+package selfmetric
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"appMetric/pkg/exposition"
+)
+
+// Metric : a metric of appMetric itself, exposed in the Prometheus text exposition format
+type Metric interface {
+	Family() *exposition.Family
+}
+
+// labelKey joins the label values as the key of a series
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// vec : the series of a metric by their label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (v *vec) checkValues(values []string) []string {
+	if len(values) == len(v.labels) {
+		return values
+	}
+
+	// the missing values are empty, and the extra ones are dropped
+	result := make([]string, len(v.labels))
+	copy(result, values)
+	return result
+}
+
+func (v *vec) toLabels(values []string) []exposition.Label {
+	result := make([]exposition.Label, 0, len(v.labels))
+	for i, name := range v.labels {
+		result = append(result, exposition.Label{Name: name, Value: values[i]})
+	}
+	return result
+}
+
+// valueVec : the series of a counter or a gauge
+type valueVec struct {
+	vec
+	mtype string
+
+	lock   sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newValueVec(name, help, mtype string, labels []string) *valueVec {
+	return &valueVec{
+		vec:    vec{name: name, help: help, labels: labels},
+		mtype:  mtype,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+}
+
+func (v *valueVec) update(values []string, f func(float64) float64) {
+	values = v.checkValues(values)
+	key := labelKey(values)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if _, exist := v.keys[key]; !exist {
+		v.keys[key] = append([]string{}, values...)
+	}
+	v.values[key] = f(v.values[key])
+}
+
+func (v *valueVec) get(values []string) float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.values[labelKey(v.checkValues(values))]
+}
+
+func (v *valueVec) Family() *exposition.Family {
+	f := exposition.NewFamily(v.name, v.help, v.mtype)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for _, key := range sortedKeys(v.keys) {
+		f.AddSample(v.values[key], v.toLabels(v.keys[key])...)
+	}
+	return f
+}
+
+// CounterVec : counters partitioned by the label values
+type CounterVec struct {
+	*valueVec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newValueVec(name, help, exposition.TypeCounter, labels)}
+}
+
+// Inc increases the counter of the label values by 1
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increases the counter of the label values; negative delta is ignored
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.update(values, func(v float64) float64 { return v + delta })
+}
+
+// Get returns the counter of the label values
+func (c *CounterVec) Get(values ...string) float64 {
+	return c.get(values)
+}
+
+// GaugeVec : gauges partitioned by the label values
+type GaugeVec struct {
+	*valueVec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newValueVec(name, help, exposition.TypeGauge, labels)}
+}
+
+// Set sets the gauge of the label values
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.update(values, func(float64) float64 { return value })
+}
+
+// Get returns the gauge of the label values
+func (g *GaugeVec) Get(values ...string) float64 {
+	return g.get(values)
+}
+
+// Replace replaces all the gauges of the first label value with the values by the second label value;
+// only for the gauges of two labels, such as the entity counts of an Alligator by category.
+func (g *GaugeVec) Replace(first string, values map[string]float64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for key, labels := range g.keys {
+		if labels[0] == first {
+			delete(g.keys, key)
+			delete(g.values, key)
+		}
+	}
+
+	for second, v := range values {
+		labels := g.checkValues([]string{first, second})
+		key := labelKey(labels)
+		g.keys[key] = labels
+		g.values[key] = v
+	}
+}
+
+// histogram : the observations of one series
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec : histograms partitioned by the label values
+type HistogramVec struct {
+	vec
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*histogram
+	keys   map[string][]string
+}
+
+// NewHistogramVec creates the histograms with the upper bounds of the buckets, in increasing order
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		vec:     vec{name: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+		keys:    make(map[string][]string),
+	}
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	values = h.checkValues(values)
+	key := labelKey(values)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, exist := h.series[key]
+	if !exist {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.keys[key] = append([]string{}, values...)
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count returns the number of observations of the label values
+func (h *HistogramVec) Count(values ...string) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if s, exist := h.series[labelKey(h.checkValues(values))]; exist {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) Family() *exposition.Family {
+	f := exposition.NewFamily(h.name, h.help, exposition.TypeHistogram)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, key := range sortedKeys(h.keys) {
+		s := h.series[key]
+		labels := h.toLabels(h.keys[key])
+		for i, upper := range h.buckets {
+			f.AddSuffixSample("_bucket", float64(s.counts[i]), withLe(labels, upper)...)
+		}
+		f.AddSuffixSample("_bucket", float64(s.count), withLe(labels, math.Inf(1))...)
+		f.AddSuffixSample("_sum", s.sum, labels...)
+		f.AddSuffixSample("_count", float64(s.count), labels...)
+	}
+	return f
+}
+
+func withLe(labels []exposition.Label, upper float64) []exposition.Label {
+	result := make([]exposition.Label, 0, len(labels)+1)
+	result = append(result, labels...)
+	return append(result, exposition.Label{Name: "le", Value: exposition.FormatValue(upper)})
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
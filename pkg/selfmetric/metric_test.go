@@ -0,0 +1,81 @@
+package selfmetric
+
+import (
+	"bytes"
+	"testing"
+
+	"appMetric/pkg/exposition"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_total", "Test counter.", "route", "code")
+	c.Inc("/pod/metrics", "200")
+	c.Add(2, "/pod/metrics", "200")
+	c.Add(-1, "/pod/metrics", "200")
+	c.Inc("/pod/metrics", "502")
+
+	if v := c.Get("/pod/metrics", "200"); v != 3 {
+		t.Errorf("Expected 3, got %v", v)
+	}
+
+	var buf bytes.Buffer
+	exposition.WriteFamilies(&buf, []*exposition.Family{c.Family()})
+	expected := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{route="/pod/metrics",code="200"} 3
+test_total{route="/pod/metrics",code="502"} 1
+`
+	if buf.String() != expected {
+		t.Errorf("Wrong exposition:\n%v\nexpected:\n%v", buf.String(), expected)
+	}
+}
+
+func TestGaugeVec_Replace(t *testing.T) {
+	g := NewGaugeVec("test_entities", "Test gauge.", "alligator", "category")
+	g.Replace("app", map[string]float64{"Istio": 3, "Redis": 2})
+	g.Replace("vapp", map[string]float64{"Istio.VApp": 1})
+	g.Replace("app", map[string]float64{"Istio": 4})
+
+	tests := []struct {
+		alligator, category string
+		value               float64
+	}{
+		{"app", "Istio", 4},
+		{"app", "Redis", 0},
+		{"vapp", "Istio.VApp", 1},
+	}
+	for _, test := range tests {
+		if v := g.Get(test.alligator, test.category); v != test.value {
+			t.Errorf("%v/%v: expected %v, got %v", test.alligator, test.category, test.value, v)
+		}
+	}
+
+	if f := g.Family(); len(f.Samples) != 2 {
+		t.Errorf("The replaced gauges should be removed: %+v", f.Samples)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1}, "getter")
+	h.Observe(0.05, "g1")
+	h.Observe(0.5, "g1")
+	h.Observe(5, "g1")
+
+	if n := h.Count("g1"); n != 3 {
+		t.Errorf("Expected 3 observations, got %d", n)
+	}
+
+	var buf bytes.Buffer
+	exposition.WriteFamilies(&buf, []*exposition.Family{h.Family()})
+	expected := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{getter="g1",le="0.1"} 1
+test_seconds_bucket{getter="g1",le="1"} 2
+test_seconds_bucket{getter="g1",le="+Inf"} 3
+test_seconds_sum{getter="g1"} 5.55
+test_seconds_count{getter="g1"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Wrong exposition:\n%v\nexpected:\n%v", buf.String(), expected)
+	}
+}
@@ -0,0 +1,50 @@
+package selfmetric
+
+import (
+	"io"
+
+	"appMetric/pkg/exposition"
+)
+
+// the buckets of the durations in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// The operational metrics of appMetric
+var (
+	// latency of each getter of each Alligator, including all its queries
+	GetterDuration = NewHistogramVec("appmetric_getter_duration_seconds",
+		"The duration of the entity getters, by Alligator, including all their queries.", durationBuckets, "alligator", "getter", "category")
+	// failures of each getter of each Alligator, including the timeouts
+	GetterErrors = NewCounterVec("appmetric_getter_errors_total",
+		"The number of failures of the entity getters, by Alligator, including the timeouts.", "alligator", "getter", "category")
+	// entities of the last full scrape of each Alligator, by category
+	Entities = NewGaugeVec("appmetric_entities",
+		"The number of entities of the last full scrape, by Alligator and category; a merged entity counts in each of its categories.",
+		"alligator", "category")
+
+	// HTTP requests by route and status code, and their latency by route
+	HTTPRequests = NewCounterVec("appmetric_http_requests_total",
+		"The number of HTTP requests, by route and status code.", "route", "code")
+	HTTPDuration = NewHistogramVec("appmetric_http_request_duration_seconds",
+		"The duration of the HTTP requests, by route.", durationBuckets, "route")
+
+	// retries of the Prometheus client, by Prometheus host
+	PrometheusRetries = NewCounterVec("appmetric_prometheus_retries_total",
+		"The number of retried requests to Prometheus, by Prometheus host.", "host")
+)
+
+var metrics = []Metric{GetterDuration, GetterErrors, Entities, HTTPRequests, HTTPDuration, PrometheusRetries}
+
+// Families returns all the operational metrics
+func Families() []*exposition.Family {
+	result := []*exposition.Family{}
+	for _, m := range metrics {
+		result = append(result, m.Family())
+	}
+	return result
+}
+
+// Write writes all the operational metrics in the text exposition format
+func Write(w io.Writer) error {
+	return exposition.WriteFamilies(w, Families())
+}
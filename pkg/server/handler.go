@@ -8,8 +8,14 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"strings"
 
+	"appMetric/pkg/addon"
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/exposition"
+	"appMetric/pkg/filter"
 	"appMetric/pkg/inter"
+	"appMetric/pkg/selfmetric"
 	"appMetric/pkg/util"
 )
 
@@ -30,6 +36,7 @@ var (
 	<tr><td><a href="/index.html"> welcome Page </a></td><td> this page </td></tr>
 	<tr><td><a href="{{.PodPath}}"> Pod metrics </a></td><td> response-time: ms, request-count</td></tr>
 	<tr><td><a href="{{.ServicePath}}"> Service metrics </a></td><td> response-time: ms, request-count</td></tr>
+	<tr><td><a href="{{.PrometheusPath}}"> Prometheus metrics </a></td><td> all the entity metrics, in Prometheus format</td></tr>
 	</table>
 	</p>
 
@@ -74,7 +81,7 @@ func genWelcomePage(path string) (string, error) {
 	}
 
 	var body bytes.Buffer
-	data := map[string]string{"IncomePath": path, "PodPath": appMetricPath, "ServicePath": serviceMetricPath}
+	data := map[string]string{"IncomePath": path, "PodPath": appMetricPath, "ServicePath": serviceMetricPath, "PrometheusPath": prometheusMetricPath}
 	if err = tmp.Execute(&body, data); err != nil {
 		glog.Errorf("Failed to execute template: %v", err)
 		return "", err
@@ -151,9 +158,26 @@ func (s *MetricServer) sendFailure(w http.ResponseWriter, r *http.Request) {
 func (s *MetricServer) sendMetrics(metrics []*inter.EntityMetric, w http.ResponseWriter, r *http.Request) {
 	//2. put metrics to response
 	resp := inter.NewMetricResponse()
-	resp.SetStatus(0, "Success")
+	resp.SetStatus(inter.StatusSuccess, "Success")
 	resp.SetMetrics(metrics)
 
+	s.sendResponse(resp, http.StatusOK, w, r)
+}
+
+func (s *MetricServer) sendResponse(resp *inter.MetricResponse, code int, w http.ResponseWriter, r *http.Request) {
+	s.sendJSON(resp, code, w, r)
+}
+
+// sendMetricResponse sends the response in the legacy format, or converted into the v2 format
+func (s *MetricServer) sendMetricResponse(resp *inter.MetricResponse, code int, v2 bool, w http.ResponseWriter, r *http.Request) {
+	if v2 {
+		s.sendJSON(inter.NewMetricResponseV2(resp), code, w, r)
+		return
+	}
+	s.sendResponse(resp, code, w, r)
+}
+
+func (s *MetricServer) sendJSON(resp interface{}, code int, w http.ResponseWriter, r *http.Request) {
 	//3. marshal to json
 	result, err := json.Marshal(resp)
 	if err != nil {
@@ -166,38 +190,351 @@ func (s *MetricServer) sendMetrics(metrics []*inter.EntityMetric, w http.Respons
 
 	//4. send response
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(code)
 	w.Write(result)
 	return
 }
 
-func (s *MetricServer) handleAppMetric(w http.ResponseWriter, r *http.Request) {
-	//1. get metrics
-	metrics, err := s.appClient.GetEntityMetrics()
+// newScrapeResponse puts the entity metrics together with the getters' status.
+// The status is StatusPartial if some of the getters failed, and
+// StatusFailure (with http.StatusBadGateway) if all of them failed.
+func newScrapeResponse(result *alligator.ScrapeResult, err error) (*inter.MetricResponse, int) {
+	resp := inter.NewMetricResponse()
+	resp.SetMetrics(result.Entities)
+	resp.SetGetterStatus(result.Getters)
+
+	code := http.StatusOK
+	failed := result.FailedNum()
+	switch {
+	case err != nil:
+		resp.SetStatus(inter.StatusFailure, err.Error())
+		code = http.StatusBadGateway
+	case failed > 0:
+		msg := fmt.Sprintf("Partial: %d of %d getters failed", failed, len(result.Getters))
+		resp.SetStatus(inter.StatusPartial, msg)
+	default:
+		resp.SetStatus(inter.StatusSuccess, "Success")
+	}
+
+	return resp, code
+}
+
+// sendSnapshot sends the last good snapshot of the collector, with its scrape time and age.
+func (s *MetricServer) sendSnapshot(collector *alligator.Collector, f *filter.EntityFilter, v2 bool, w http.ResponseWriter, r *http.Request) {
+	snapshot := collector.GetSnapshot()
+	if snapshot == nil {
+		resp := inter.NewMetricResponse()
+		resp.SetStatus(inter.StatusFailure, fmt.Sprintf("No successful scrape of %v yet", collector.Name()))
+		s.sendMetricResponse(resp, http.StatusServiceUnavailable, v2, w, r)
+		return
+	}
+
+	resp, code := newScrapeResponse(snapshot.Result, nil)
+	resp.SetScrapeTime(snapshot.Timestamp)
+	resp.SetMetrics(f.FilterEntities(resp.Data))
+	s.sendMetricResponse(resp, code, v2, w, r)
+}
+
+// parseQueryOptions gets the query options from the parameters of the request, such as "?window=1m&time=1524246000"
+func parseQueryOptions(r *http.Request) (*alligator.QueryOptions, error) {
+	opts := &alligator.QueryOptions{}
+
+	if window := r.URL.Query().Get("window"); len(window) > 0 {
+		if err := addon.ValidateWindow(window); err != nil {
+			return nil, err
+		}
+		opts.Window = window
+	}
+
+	if v := r.URL.Query().Get("time"); len(v) > 0 {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Time = t
+	}
+
+	return opts, nil
+}
+
+// sendBadRequest sends a failure response for the invalid request parameters
+func (s *MetricServer) sendBadRequest(err error, v2 bool, w http.ResponseWriter, r *http.Request) {
+	resp := inter.NewMetricResponse()
+	resp.SetStatus(inter.StatusFailure, err.Error())
+	s.sendMetricResponse(resp, http.StatusBadRequest, v2, w, r)
+}
+
+// handleEntityMetric serves the entity metrics of the Alligator; the cached snapshot of the collector is served
+// if there is one, unless the request sets the query options. The response is in the v2 format if v2 is true.
+func (s *MetricServer) handleEntityMetric(client *alligator.Alligator, collector *alligator.Collector, v2 bool, w http.ResponseWriter, r *http.Request) {
+	//1. parse the query options and the filter
+	opts, err := parseQueryOptions(r)
 	if err != nil {
-		glog.Errorf("Failed to get Application Metrics: %v", err)
-		s.sendFailure(w, r)
+		glog.Errorf("Invalid request %v: %v", r.URL, err)
+		s.sendBadRequest(err, v2, w, r)
 		return
 	}
 
-	glog.V(3).Infof("App metrics num: %v", len(metrics))
+	f, err := filter.ParseEntityFilter(r.URL.Query())
+	if err != nil {
+		glog.Errorf("Invalid request %v: %v", r.URL, err)
+		s.sendBadRequest(err, v2, w, r)
+		return
+	}
 
-	//2. put metrics to response
-	s.sendMetrics(metrics, w, r)
-	return
+	if collector != nil && len(opts.Window) < 1 && opts.Time.IsZero() {
+		s.sendSnapshot(collector, f, v2, w, r)
+		return
+	}
+
+	//2. get metrics
+	result, err := client.GetEntityMetricsWithOptions(r.Context(), opts)
+	if err != nil {
+		glog.Errorf("Failed to get metrics for %v: %v", r.URL.Path, err)
+	}
+	glog.V(3).Infof("%v metrics num: %v", r.URL.Path, len(result.Entities))
+
+	//3. put metrics to response
+	resp, code := newScrapeResponse(result, err)
+	resp.SetMetrics(f.FilterEntities(resp.Data))
+	resp.SetWindow(opts.Window)
+	if !opts.Time.IsZero() {
+		resp.SetQueryTime(opts.Time)
+	}
+	s.sendMetricResponse(resp, code, v2, w, r)
 }
 
-func (s *MetricServer) handleServiceMetric(w http.ResponseWriter, r *http.Request) {
-	//1. get metrics
-	metrics, err := s.vappClient.GetEntityMetrics()
+// handleEntityMetricRange serves the entity metrics of the Alligator over the time range of the request,
+// in the v2 format if v2 is true.
+func (s *MetricServer) handleEntityMetricRange(client *alligator.Alligator, v2 bool, w http.ResponseWriter, r *http.Request) {
+	//1. parse the range and the query options
+	rg, err := parseRange(r)
 	if err != nil {
-		glog.Errorf("Failed to get Application Metrics: %v", err)
-		s.sendFailure(w, r)
+		glog.Errorf("Invalid request %v: %v", r.URL, err)
+		s.sendRangeResponse(&inter.RangeResponse{Status: inter.StatusFailure, Message: err.Error()}, http.StatusBadRequest, v2, w, r)
 		return
 	}
 
-	//2. put metrics to response
-	s.sendMetrics(metrics, w, r)
+	opts, err := parseQueryOptions(r)
+	if err != nil {
+		glog.Errorf("Invalid request %v: %v", r.URL, err)
+		s.sendRangeResponse(&inter.RangeResponse{Status: inter.StatusFailure, Message: err.Error()}, http.StatusBadRequest, v2, w, r)
+		return
+	}
+
+	f, err := filter.ParseEntityFilter(r.URL.Query())
+	if err != nil {
+		glog.Errorf("Invalid request %v: %v", r.URL, err)
+		s.sendRangeResponse(&inter.RangeResponse{Status: inter.StatusFailure, Message: err.Error()}, http.StatusBadRequest, v2, w, r)
+		return
+	}
+
+	//2. get metrics
+	result, err := client.GetEntityMetricsRange(r.Context(), rg, opts)
+	if err != nil {
+		glog.Errorf("Failed to get metrics for %v: %v", r.URL.Path, err)
+	}
+	glog.V(3).Infof("%v series num: %v", r.URL.Path, len(result.Series))
+
+	//3. put metrics to response
+	resp := inter.NewRangeResponse()
+	resp.Data = f.FilterSeries(result.Series)
+	resp.Getters = result.Getters
+	resp.Start = rg.Start.Unix()
+	resp.End = rg.End.Unix()
+	resp.Step = rg.Step.Seconds()
+	resp.Window = opts.Window
+	resp.Units = inter.SeriesUnits(resp.Data)
+
+	code := http.StatusOK
+	failed := result.FailedNum()
+	switch {
+	case err != nil:
+		resp.SetStatus(inter.StatusFailure, err.Error())
+		code = http.StatusBadGateway
+	case failed > 0:
+		resp.SetStatus(inter.StatusPartial, fmt.Sprintf("Partial: %d of %d getters failed", failed, len(result.Getters)))
+	default:
+		resp.SetStatus(inter.StatusSuccess, "Success")
+	}
+	s.sendRangeResponse(resp, code, v2, w, r)
+}
+
+// sendRangeResponse sends the range response in the legacy format, or converted into the v2 format
+func (s *MetricServer) sendRangeResponse(resp *inter.RangeResponse, code int, v2 bool, w http.ResponseWriter, r *http.Request) {
+	if v2 {
+		s.sendJSON(inter.NewRangeResponseV2(resp), code, w, r)
+		return
+	}
+	s.sendJSON(resp, code, w, r)
+}
+
+// handleSingleEntityMetric serves the metrics of the entity with the uid, or 404 if there is no such entity.
+// The cached snapshot of the collector is served if there is one, unless the request sets the query options;
+// otherwise the getters narrow their queries to the entity if they can.
+// The response is the entity itself, or a v2 response with only the entity if v2 is true.
+func (s *MetricServer) handleSingleEntityMetric(client *alligator.Alligator, collector *alligator.Collector, uid string, v2 bool, w http.ResponseWriter, r *http.Request) {
+	//1. parse the query options
+	opts, err := parseQueryOptions(r)
+	if err != nil {
+		glog.Errorf("Invalid request %v: %v", r.URL, err)
+		s.sendEntityError(err.Error(), http.StatusBadRequest, v2, w, r)
+		return
+	}
+
+	//2. get the entity, with the outcome of the getters
+	var entity *inter.EntityMetric
+	var resp *inter.MetricResponse
+	code := http.StatusOK
+	if collector != nil && len(opts.Window) < 1 && opts.Time.IsZero() {
+		snapshot := collector.GetSnapshot()
+		if snapshot == nil {
+			msg := fmt.Sprintf("No successful scrape of %v yet", collector.Name())
+			s.sendEntityError(msg, http.StatusServiceUnavailable, v2, w, r)
+			return
+		}
+		for _, e := range snapshot.Result.Entities {
+			if e.UID == uid {
+				entity = e
+				break
+			}
+		}
+		resp, code = newScrapeResponse(snapshot.Result, nil)
+		resp.SetScrapeTime(snapshot.Timestamp)
+	} else {
+		var result *alligator.ScrapeResult
+		entity, result, err = client.GetEntityMetric(r.Context(), uid, opts)
+		if err != nil {
+			glog.Errorf("Failed to get metrics for %v: %v", r.URL.Path, err)
+			s.sendEntityError(err.Error(), http.StatusBadGateway, v2, w, r)
+			return
+		}
+		resp, code = newScrapeResponse(result, nil)
+		resp.SetWindow(opts.Window)
+		if !opts.Time.IsZero() {
+			resp.SetQueryTime(opts.Time)
+		}
+	}
+
+	//3. send the entity
+	if entity == nil {
+		s.sendEntityError(fmt.Sprintf("Entity [%v] is not found", uid), http.StatusNotFound, v2, w, r)
+		return
+	}
+	if !v2 {
+		s.sendJSON(entity, http.StatusOK, w, r)
+		return
+	}
+	resp.SetMetrics([]*inter.EntityMetric{entity})
+	s.sendMetricResponse(resp, code, v2, w, r)
+}
+
+// sendEntityError sends the failure of a single-entity request: an ErrorResponse, or a v2 response if v2 is true
+func (s *MetricServer) sendEntityError(msg string, code int, v2 bool, w http.ResponseWriter, r *http.Request) {
+	if v2 {
+		resp := inter.NewMetricResponse()
+		resp.SetStatus(inter.StatusFailure, msg)
+		s.sendMetricResponse(resp, code, v2, w, r)
+		return
+	}
+	s.sendJSON(inter.NewErrorResponse(msg), code, w, r)
+}
+
+func (s *MetricServer) handleAppMetric(v2 bool, w http.ResponseWriter, r *http.Request) {
+	s.handleEntityMetric(s.appClient, s.appCollector, v2, w, r)
+}
+
+func (s *MetricServer) handleServiceMetric(v2 bool, w http.ResponseWriter, r *http.Request) {
+	s.handleEntityMetric(s.vappClient, s.vappCollector, v2, w, r)
+}
+
+// handleSinglePodMetric serves "/pod/metrics/{uid}"
+func (s *MetricServer) handleSinglePodMetric(uid string, v2 bool, w http.ResponseWriter, r *http.Request) {
+	s.handleSingleEntityMetric(s.appClient, s.appCollector, uid, v2, w, r)
+}
+
+// handleSingleServiceMetric serves "/service/metrics/{namespace}/{name}"
+func (s *MetricServer) handleSingleServiceMetric(path string, v2 bool, w http.ResponseWriter, r *http.Request) {
+	items := strings.Split(path, "/")
+	if len(items) != 2 || len(items[0]) < 1 || len(items[1]) < 1 {
+		msg := fmt.Sprintf("Invalid service [%v], should be {namespace}/{name}", path)
+		s.sendEntityError(msg, http.StatusNotFound, v2, w, r)
+		return
+	}
+
+	uid := fmt.Sprintf("%s/%s", items[0], items[1])
+	s.handleSingleEntityMetric(s.vappClient, s.vappCollector, uid, v2, w, r)
+}
+
+func (s *MetricServer) handleAppMetricRange(v2 bool, w http.ResponseWriter, r *http.Request) {
+	s.handleEntityMetricRange(s.appClient, v2, w, r)
+}
+
+func (s *MetricServer) handleServiceMetricRange(v2 bool, w http.ResponseWriter, r *http.Request) {
+	s.handleEntityMetricRange(s.vappClient, v2, w, r)
+}
+
+// getScrapeResult gets the entity metrics of the Alligator, from the cached snapshot of the collector if there is one
+func (s *MetricServer) getScrapeResult(client *alligator.Alligator, collector *alligator.Collector, r *http.Request) (*alligator.ScrapeResult, error) {
+	if collector == nil {
+		return client.GetEntityMetrics(r.Context())
+	}
+
+	snapshot := collector.GetSnapshot()
+	if snapshot == nil {
+		return nil, fmt.Errorf("no successful scrape of %v yet", collector.Name())
+	}
+	return snapshot.Result, nil
+}
+
+// handlePrometheusMetric serves the entity metrics of both the pods and the services as gauges
+// in the Prometheus text exposition format, for federation. The filter parameters apply too.
+func (s *MetricServer) handlePrometheusMetric(w http.ResponseWriter, r *http.Request) {
+	f, err := filter.ParseEntityFilter(r.URL.Query())
+	if err != nil {
+		glog.Errorf("Invalid request %v: %v", r.URL, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	//1. get the metrics; the entities of the failed Alligator are missing
+	entities := []*inter.EntityMetric{}
+	errs := []string{}
+	for _, src := range []struct {
+		client    *alligator.Alligator
+		collector *alligator.Collector
+	}{
+		{s.appClient, s.appCollector},
+		{s.vappClient, s.vappCollector},
+	} {
+		result, err := s.getScrapeResult(src.client, src.collector, r)
+		if err != nil {
+			glog.Errorf("Failed to get metrics for %v: %v", r.URL.Path, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		entities = append(entities, f.FilterEntities(result.Entities)...)
+	}
+
+	if len(errs) == 2 {
+		http.Error(w, strings.Join(errs, "; "), http.StatusServiceUnavailable)
+		return
+	}
+
+	//2. write the gauges
+	w.Header().Set("Content-Type", exposition.ContentType)
+	if err := exposition.WriteFamilies(w, exposition.EntityFamilies(entities)); err != nil {
+		glog.Errorf("Failed to write metrics for %v: %v", r.URL.Path, err)
+	}
+	glog.V(3).Infof("%v metrics num: %v", r.URL.Path, len(entities))
+}
+
+// handleSelfMetric serves the operational metrics of appMetric itself in the Prometheus text exposition format
+func (s *MetricServer) handleSelfMetric(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", exposition.ContentType)
+	if err := selfmetric.Write(w); err != nil {
+		glog.Errorf("Failed to write metrics for %v: %v", r.URL.Path, err)
+	}
 }
 
 func (s *MetricServer) handleFakeMetric(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/inter"
+)
+
+func newFakeServer() *MetricServer {
+	app := newFakeAlligator("app", &fakeGetter{name: "g", uids: []string{"10.2.1.104", "10.2.1.105"}})
+	vapp := newFakeAlligator("vapp", &fakeGetter{name: "g", uids: []string{"default/productpage"}})
+	return NewMetricServer(0, app, vapp)
+}
+
+// serveJSON sends the request to the server, and unmarshals the response into resp
+func serveJSON(t *testing.T, s *MetricServer, path string, resp interface{}) int {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Errorf("Failed to unmarshal response of %v %q: %v", path, w.Body.String(), err)
+	}
+	return w.Code
+}
+
+func TestMetricServer_V2(t *testing.T) {
+	s := newFakeServer()
+
+	//1. legacy
+	resp := &inter.MetricResponse{}
+	if code := serveJSON(t, s, appMetricPath, resp); code != http.StatusOK || len(resp.Data) != 2 {
+		t.Errorf("Wrong response of %v: %d, %+v", appMetricPath, code, resp)
+	}
+
+	//2. v2: the same entities, with all the fields
+	resp2 := &inter.MetricResponseV2{}
+	if code := serveJSON(t, s, apiV2Prefix+appMetricPath, resp2); code != http.StatusOK {
+		t.Errorf("Wrong code of v2: %d", code)
+	}
+	if resp2.Status != inter.StatusSuccess || len(resp2.Data) != 2 || len(resp2.Getters) != 1 || resp2.Timestamp == 0 || resp2.Units == nil {
+		t.Errorf("Wrong v2 response: %+v", resp2)
+	}
+
+	resp2 = &inter.MetricResponseV2{}
+	if code := serveJSON(t, s, apiV2Prefix+serviceMetricPath, resp2); code != http.StatusOK || len(resp2.Data) != 1 {
+		t.Errorf("Wrong v2 response of services: %d, %+v", code, resp2)
+	}
+
+	//3. invalid options
+	resp2 = &inter.MetricResponseV2{}
+	if code := serveJSON(t, s, apiV2Prefix+appMetricPath+"?window=abc", resp2); code != http.StatusBadRequest || resp2.Status != inter.StatusFailure {
+		t.Errorf("Wrong v2 response of invalid window: %d, %+v", code, resp2)
+	}
+}
+
+func TestMetricServer_SingleEntity(t *testing.T) {
+	s := newFakeServer()
+
+	//1. legacy: the entity itself
+	e := &inter.EntityMetric{}
+	if code := serveJSON(t, s, appMetricPath+"/10.2.1.104", e); code != http.StatusOK || e.UID != "10.2.1.104" || e.Metrics[inter.TPS] != 10 {
+		t.Errorf("Wrong entity: %d, %+v", code, e)
+	}
+
+	e = &inter.EntityMetric{}
+	if code := serveJSON(t, s, serviceMetricPath+"/default/productpage", e); code != http.StatusOK || e.UID != "default/productpage" {
+		t.Errorf("Wrong service: %d, %+v", code, e)
+	}
+
+	//2. v2: a response with only the entity
+	resp := &inter.MetricResponseV2{}
+	if code := serveJSON(t, s, apiV2Prefix+appMetricPath+"/10.2.1.105", resp); code != http.StatusOK || len(resp.Data) != 1 || resp.Data[0].UID != "10.2.1.105" {
+		t.Errorf("Wrong v2 entity: %d, %+v", code, resp)
+	}
+
+	//3. unknown entities
+	for _, path := range []string{appMetricPath + "/10.2.1.200", serviceMetricPath + "/default/reviews", serviceMetricPath + "/productpage"} {
+		errResp := &inter.ErrorResponse{}
+		if code := serveJSON(t, s, path, errResp); code != http.StatusNotFound || errResp.Status != inter.StatusFailure {
+			t.Errorf("Wrong response of %v: %d, %+v", path, code, errResp)
+		}
+
+		resp := &inter.MetricResponseV2{}
+		if code := serveJSON(t, s, apiV2Prefix+path, resp); code != http.StatusNotFound || resp.Status != inter.StatusFailure || len(resp.Data) != 0 {
+			t.Errorf("Wrong v2 response of %v: %d, %+v", path, code, resp)
+		}
+	}
+}
+
+func TestMetricServer_SingleEntitySnapshot(t *testing.T) {
+	s := newFakeServer()
+	appCollector := alligator.NewCollector("app", s.appClient, time.Minute)
+	s.SetCollectors(appCollector, alligator.NewCollector("vapp", s.vappClient, time.Minute))
+
+	//1. no snapshot yet
+	errResp := &inter.ErrorResponse{}
+	if code := serveJSON(t, s, appMetricPath+"/10.2.1.104", errResp); code != http.StatusServiceUnavailable {
+		t.Errorf("Wrong response without snapshot: %d, %+v", code, errResp)
+	}
+
+	//2. from the snapshot
+	appCollector.Scrape(context.Background())
+	resp := &inter.MetricResponseV2{}
+	if code := serveJSON(t, s, apiV2Prefix+appMetricPath+"/10.2.1.104", resp); code != http.StatusOK || len(resp.Data) != 1 || resp.Timestamp == 0 {
+		t.Errorf("Wrong v2 entity from snapshot: %d, %+v", code, resp)
+	}
+
+	errResp = &inter.ErrorResponse{}
+	if code := serveJSON(t, s, appMetricPath+"/10.2.1.200", errResp); code != http.StatusNotFound {
+		t.Errorf("Wrong response of unknown entity: %d, %+v", code, errResp)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/inter"
+)
+
+const (
+	// without background scraping, /readyz checks Prometheus if there is no scrape or probe within this period
+	readyScrapeAge = 30 * time.Second
+	// the timeout of the scrape by /readyz, before the first successful one
+	readyScrapeTimeout = 5 * time.Second
+	// the timeout of the probe of /readyz
+	readyProbeTimeout = 2 * time.Second
+)
+
+// SetUnreachableTimeout sets how long Prometheus can be unreachable before /readyz reports degraded; 0 to disable
+func (s *MetricServer) SetUnreachableTimeout(timeout time.Duration) {
+	s.unreachableTimeout = timeout
+}
+
+// handleHealth serves the liveness: the server is up
+func (s *MetricServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.sendJSON(inter.NewHealthResponse(inter.HealthOK), http.StatusOK, w, r)
+}
+
+// handleReady serves the readiness: not-ready until each Alligator can serve metrics, and degraded if
+// Prometheus has been unreachable for unreachableTimeout. Both are reported with 503: the metrics of
+// a degraded instance are stale, so it should be taken out of service until Prometheus is back.
+func (s *MetricServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	resp := inter.NewHealthResponse(inter.HealthReady)
+	resp.AddCheck(s.checkReady("app", s.appClient, s.appCollector, r))
+	resp.AddCheck(s.checkReady("vapp", s.vappClient, s.vappCollector, r))
+
+	code := http.StatusOK
+	if resp.Status != inter.HealthReady {
+		code = http.StatusServiceUnavailable
+	}
+	s.sendJSON(resp, code, w, r)
+}
+
+// checkReady checks one Alligator:
+// in collector mode, it is ready once the collector has a snapshot to serve;
+// otherwise, it is ready once a scrape succeeded. Without requests, /readyz scrapes by itself until the first
+// success, and then only probes Prometheus by a cheap query if there is no recent scrape.
+func (s *MetricServer) checkReady(name string, client *alligator.Alligator, collector *alligator.Collector, r *http.Request) *inter.HealthCheck {
+	//1. check Prometheus if there is no recent outcome of the requests
+	health := client.Health()
+	if collector == nil && time.Since(health.LastScrape) > readyScrapeAge && time.Since(health.LastProbe) > readyScrapeAge {
+		if health.LastSuccess.IsZero() {
+			ctx, cancel := context.WithTimeout(r.Context(), readyScrapeTimeout)
+			client.GetEntityMetrics(ctx)
+			cancel()
+		} else {
+			client.Probe(r.Context(), readyProbeTimeout)
+		}
+		health = client.Health()
+	}
+
+	check := &inter.HealthCheck{
+		Name:   name,
+		Status: inter.HealthReady,
+	}
+	if !health.LastSuccess.IsZero() {
+		check.LastSuccess = health.LastSuccess.Unix()
+	}
+
+	//2. whether Prometheus is reachable, by the latest of the scrapes and the probes
+	failingSince, lastError := health.FailingSince, health.LastError
+	if health.LastProbe.After(health.LastScrape) {
+		failingSince, lastError = health.ProbeFailingSince, health.ProbeError
+	}
+	if !failingSince.IsZero() {
+		check.FailingSince = failingSince.Unix()
+		check.Error = lastError
+	}
+
+	//3. the readiness
+	switch {
+	case collector != nil && collector.GetSnapshot() == nil:
+		check.Status = inter.HealthNotReady
+		if len(check.Error) < 1 {
+			check.Error = "no snapshot yet"
+		}
+	case collector == nil && health.LastSuccess.IsZero():
+		check.Status = inter.HealthNotReady
+	case !failingSince.IsZero() && s.unreachableTimeout > 0 && time.Since(failingSince) >= s.unreachableTimeout:
+		check.Status = inter.HealthDegraded
+	}
+	return check
+}
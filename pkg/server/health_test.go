@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"appMetric/pkg/alligator"
+	"appMetric/pkg/inter"
+	"appMetric/pkg/promclient"
+)
+
+type fakeGetter struct {
+	name string
+	err  error
+	uids []string
+}
+
+func (g *fakeGetter) Name() string {
+	return g.name
+}
+
+func (g *fakeGetter) Category() string {
+	return "Fake"
+}
+
+func (g *fakeGetter) GetEntityMetric(client promclient.MetricClient) ([]*inter.EntityMetric, error) {
+	result := []*inter.EntityMetric{}
+	if g.err != nil {
+		return result, g.err
+	}
+
+	for _, uid := range g.uids {
+		e := inter.NewEntityMetric(uid, inter.ApplicationType)
+		e.SetLabel(inter.Category, g.Category())
+		e.SetMetric(inter.TPS, 10)
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// newFakeAlligator creates an Alligator without Prometheus, with one fake getter
+func newFakeAlligator(name string, getter *fakeGetter) *alligator.Alligator {
+	c := alligator.NewAlligator(nil)
+	c.SetName(name)
+	c.AddGetter(getter)
+	return c
+}
+
+func getReady(t *testing.T, s *MetricServer) (int, *inter.HealthResponse) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", readyPath, nil))
+
+	resp := &inter.HealthResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Errorf("Failed to unmarshal response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, resp
+}
+
+func TestMetricServer_Health(t *testing.T) {
+	s := NewMetricServer(0, newFakeAlligator("app", &fakeGetter{name: "g"}), newFakeAlligator("vapp", &fakeGetter{name: "g"}))
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", healthPath, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestMetricServer_ReadyOnDemand(t *testing.T) {
+	app := &fakeGetter{name: "g", err: fmt.Errorf("query failed")}
+	vapp := &fakeGetter{name: "g", uids: []string{"b"}}
+	appClient := newFakeAlligator("app", app)
+	s := NewMetricServer(0, appClient, newFakeAlligator("vapp", vapp))
+
+	//1. a probe is not a scrape: not ready before a successful scrape
+	appClient.Probe(context.Background(), time.Second)
+	code, resp := getReady(t, s)
+	if code != http.StatusServiceUnavailable || resp.Status != inter.HealthNotReady {
+		t.Errorf("Expected not-ready with %d, got %d: %+v", http.StatusServiceUnavailable, code, resp)
+	}
+
+	//2. ready after a successful scrape; vapp was scraped by /readyz itself
+	app.err = nil
+	appClient.GetEntityMetrics(context.Background())
+	code, resp = getReady(t, s)
+	if code != http.StatusOK || resp.Status != inter.HealthReady {
+		t.Errorf("Expected ready with %d, got %d: %+v", http.StatusOK, code, resp)
+	}
+}
+
+func TestMetricServer_ReadyCollector(t *testing.T) {
+	appClient := newFakeAlligator("app", &fakeGetter{name: "g", uids: []string{"a"}})
+	vappClient := newFakeAlligator("vapp", &fakeGetter{name: "g", uids: []string{"b"}})
+	appCollector := alligator.NewCollector("app", appClient, time.Minute)
+	vappCollector := alligator.NewCollector("vapp", vappClient, time.Minute)
+	s := NewMetricServer(0, appClient, vappClient)
+	s.SetCollectors(appCollector, vappCollector)
+
+	//1. a successful scrape outside the collector does not make a snapshot
+	appClient.GetEntityMetrics(context.Background())
+	vappClient.GetEntityMetrics(context.Background())
+	code, resp := getReady(t, s)
+	if code != http.StatusServiceUnavailable || resp.Status != inter.HealthNotReady {
+		t.Errorf("Expected not-ready with %d, got %d: %+v", http.StatusServiceUnavailable, code, resp)
+	}
+
+	//2. ready once both collectors have a snapshot
+	appCollector.Scrape(context.Background())
+	vappCollector.Scrape(context.Background())
+	code, resp = getReady(t, s)
+	if code != http.StatusOK || resp.Status != inter.HealthReady {
+		t.Errorf("Expected ready with %d, got %d: %+v", http.StatusOK, code, resp)
+	}
+}
+
+func TestMetricServer_ReadyDegraded(t *testing.T) {
+	app := &fakeGetter{name: "g", uids: []string{"a"}}
+	appClient := newFakeAlligator("app", app)
+	vappClient := newFakeAlligator("vapp", &fakeGetter{name: "g", uids: []string{"b"}})
+	s := NewMetricServer(0, appClient, vappClient)
+	s.SetUnreachableTimeout(time.Millisecond)
+
+	appClient.GetEntityMetrics(context.Background())
+	vappClient.GetEntityMetrics(context.Background())
+	app.err = fmt.Errorf("query failed")
+	appClient.GetEntityMetrics(context.Background())
+	time.Sleep(5 * time.Millisecond)
+
+	//1. degraded fails the probe too
+	code, resp := getReady(t, s)
+	if code != http.StatusServiceUnavailable || resp.Status != inter.HealthDegraded {
+		t.Errorf("Expected degraded with %d, got %d: %+v", http.StatusServiceUnavailable, code, resp)
+	}
+	if len(resp.Checks) != 2 || resp.Checks[0].Error == "" || resp.Checks[0].LastSuccess == 0 {
+		t.Errorf("Expected the failing check of app: %+v", resp.Checks)
+	}
+
+	//2. disabled
+	s.SetUnreachableTimeout(0)
+	if code, resp := getReady(t, s); code != http.StatusOK {
+		t.Errorf("Expected ready with %d, got %d: %+v", http.StatusOK, code, resp)
+	}
+}
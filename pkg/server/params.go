@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"appMetric/pkg/promclient"
+)
+
+const (
+	defaultRangeStep = time.Minute
+)
+
+// parseTime parses a time in RFC3339, such as "2018-04-20T17:40:00Z", or a unix timestamp in seconds, such as "1524246000"
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return time.Time{}, fmt.Errorf("invalid time: [%v], should be RFC3339 or unix timestamp", s)
+	}
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+}
+
+// parseStep parses a duration, such as "5m", or a number of seconds, such as "300"
+func parseStep(s string) (time.Duration, error) {
+	if du, err := time.ParseDuration(s); err == nil {
+		return du, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid step: [%v], should be a duration or seconds", s)
+	}
+	return time.Duration(v * float64(time.Second)), nil
+}
+
+// parseRange gets the range from the parameters of the request: "start" is required,
+// "end" is now by default, and "step" is 1m by default.
+func parseRange(r *http.Request) (*promclient.Range, error) {
+	params := r.URL.Query()
+
+	v := params.Get("start")
+	if len(v) < 1 {
+		return nil, fmt.Errorf("start is required")
+	}
+	start, err := parseTime(v)
+	if err != nil {
+		return nil, err
+	}
+
+	end := time.Now()
+	if v := params.Get("end"); len(v) > 0 {
+		if end, err = parseTime(v); err != nil {
+			return nil, err
+		}
+	}
+
+	step := defaultRangeStep
+	if v := params.Get("step"); len(v) > 0 {
+		if step, err = parseStep(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return promclient.NewRange(start, end, step)
+}
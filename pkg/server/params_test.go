@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"appMetric/pkg/inter"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect time.Time
+		valid  bool
+	}{
+		{"2018-04-20T17:40:00Z", time.Unix(1524246000, 0), true},
+		{"2018-04-20T19:40:00+02:00", time.Unix(1524246000, 0), true},
+		{"1524246000", time.Unix(1524246000, 0), true},
+		{"1524246000.5", time.Unix(1524246000, int64(500*time.Millisecond)), true},
+		{"2018-04-20 17:40:00", time.Time{}, false},
+		{"NaN", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		v, err := parseTime(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validation of %q: %v", test.input, err)
+			continue
+		}
+		if test.valid && !v.Equal(test.expect) {
+			t.Errorf("Wrong time of %q: %v Vs. %v", test.input, v, test.expect)
+		}
+	}
+}
+
+func TestParseStep(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect time.Duration
+		valid  bool
+	}{
+		{"5m", 5 * time.Minute, true},
+		{"30s", 30 * time.Second, true},
+		{"300", 5 * time.Minute, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{"5x", 0, false},
+		{"Inf", 0, false},
+	}
+
+	for _, test := range tests {
+		v, err := parseStep(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validation of %q: %v", test.input, err)
+			continue
+		}
+		if test.valid && v != test.expect {
+			t.Errorf("Wrong step of %q: %v Vs. %v", test.input, v, test.expect)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		query string
+		start int64
+		end   int64
+		step  time.Duration
+		valid bool
+	}{
+		{"start=1524246000&end=1524246600&step=5m", 1524246000, 1524246600, 5 * time.Minute, true},
+		{"start=2018-04-20T17:40:00Z&end=2018-04-20T17:50:00Z", 1524246000, 1524246600, defaultRangeStep, true},
+		{"start=1524246000&end=1524246000&step=60", 1524246000, 1524246000, time.Minute, true},
+		// start is required
+		{"end=1524246600", 0, 0, 0, false},
+		// start after end
+		{"start=1524246600&end=1524246000", 0, 0, 0, false},
+		// step is not whole seconds, or too small
+		{"start=1524246000&end=1524246600&step=1.5", 0, 0, 0, false},
+		{"start=1524246000&end=1524246600&step=500ms", 0, 0, 0, false},
+		{"start=1524246000&end=1524246600&step=0", 0, 0, 0, false},
+		// too many points
+		{"start=1524246000&end=1524346000&step=1s", 0, 0, 0, false},
+		{"start=yesterday", 0, 0, 0, false},
+		{"start=1524246000&end=now", 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		r, err := parseRange(httptest.NewRequest("GET", appMetricRangePath+"?"+test.query, nil))
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validation of %q: %v", test.query, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if r.Start.Unix() != test.start || r.End.Unix() != test.end || r.Step != test.step {
+			t.Errorf("Wrong range of %q: %+v", test.query, r)
+		}
+	}
+
+	// end is now by default
+	start := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	r, err := parseRange(httptest.NewRequest("GET", appMetricRangePath+"?start="+start, nil))
+	if err != nil || time.Since(r.End) > time.Minute {
+		t.Errorf("Wrong default end: %v, %+v", err, r)
+	}
+}
+
+func TestMetricServer_Range(t *testing.T) {
+	app := newFakeAlligator("app", &fakeGetter{name: "g", uids: []string{"a", "b"}})
+	s := NewMetricServer(0, app, newFakeAlligator("vapp", &fakeGetter{name: "g"}))
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{appMetricRangePath + "?start=1524246000&end=1524246120&step=1m", http.StatusOK},
+		{apiV2Prefix + appMetricRangePath + "?start=1524246000&end=1524246120&step=1m", http.StatusOK},
+		{appMetricRangePath + "?end=1524246120", http.StatusBadRequest},
+		{appMetricRangePath + "?start=1524246120&end=1524246000", http.StatusBadRequest},
+		{apiV2Prefix + appMetricRangePath + "?start=1524246000&end=1524246120&step=0.5", http.StatusBadRequest},
+		{appMetricRangePath + "?start=1524246000&end=1524246120&window=abc", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.code {
+			t.Errorf("Wrong code of %v: %d Vs. %d, %v", test.path, w.Code, test.code, w.Body.String())
+		}
+	}
+
+	// 3 samples of each entity
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", apiV2Prefix+appMetricRangePath+"?start=1524246000&end=1524246120&step=1m", nil))
+	resp := &inter.RangeResponseV2{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Errorf("Failed to unmarshal response %q: %v", w.Body.String(), err)
+		return
+	}
+	if resp.Start != 1524246000 || resp.End != 1524246120 || resp.Step != 60 || len(resp.Data) != 2 {
+		t.Errorf("Wrong response: %+v", resp)
+		return
+	}
+	if samples := resp.Data[0].Metrics[inter.TPS]; len(samples) != 3 || samples[2].Timestamp != 1524246120 {
+		t.Errorf("Wrong samples: %+v", samples)
+	}
+}
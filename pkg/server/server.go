@@ -5,9 +5,12 @@ import (
 	"github.com/golang/glog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"appMetric/pkg/alligator"
+	"appMetric/pkg/selfmetric"
 	"appMetric/pkg/util"
 )
 
@@ -18,12 +21,35 @@ type MetricServer struct {
 
 	appClient  *alligator.Alligator
 	vappClient *alligator.Alligator
+
+	// if set, metrics are served from their snapshots
+	appCollector  *alligator.Collector
+	vappCollector *alligator.Collector
+
+	// how long Prometheus can be unreachable before /readyz reports degraded; 0 to disable
+	unreachableTimeout time.Duration
 }
 
 const (
 	appMetricPath     = "/pod/metrics"
 	serviceMetricPath = "/service/metrics"
 	fakeMetricPath    = "/fake/metrics"
+	// entity metrics in the Prometheus text exposition format
+	prometheusMetricPath = "/metrics"
+
+	// the operational metrics of appMetric itself
+	selfMetricPath = "/internal/metrics"
+
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+
+	appMetricRangePath     = appMetricPath + "/range"
+	serviceMetricRangePath = serviceMetricPath + "/range"
+
+	// the prefix of the v2 API, such as "/v2/pod/metrics"; the metric APIs without it serve the legacy format
+	apiV2Prefix = "/v2"
+
+	defaultUnreachableTimeout = 5 * time.Minute
 )
 
 func NewMetricServer(port int, appClient, vappclient *alligator.Alligator) *MetricServer {
@@ -46,9 +72,18 @@ func NewMetricServer(port int, appClient, vappclient *alligator.Alligator) *Metr
 		host:       host,
 		appClient:  appClient,
 		vappClient: vappclient,
+
+		unreachableTimeout: defaultUnreachableTimeout,
 	}
 }
 
+// SetCollectors makes the server serve the cached snapshots of the collectors,
+// instead of querying Prometheus for each request.
+func (s *MetricServer) SetCollectors(appCollector, vappCollector *alligator.Collector) {
+	s.appCollector = appCollector
+	s.vappCollector = vappCollector
+}
+
 func (s *MetricServer) Run() {
 	server := http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
@@ -59,7 +94,18 @@ func (s *MetricServer) Run() {
 	panic(server.ListenAndServe())
 }
 
+// ServeHTTP serves the request, and records its count and latency by route
 func (s *MetricServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	s.serve(recorder, r)
+
+	route := routeOf(r.URL.Path)
+	selfmetric.HTTPRequests.Inc(route, strconv.Itoa(recorder.code))
+	selfmetric.HTTPDuration.Observe(time.Since(start).Seconds(), route)
+}
+
+func (s *MetricServer) serve(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	glog.V(2).Infof("Begin to handle path: %v", path)
 
@@ -68,13 +114,16 @@ func (s *MetricServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if strings.EqualFold(path, appMetricPath) {
-		s.handleAppMetric(w, r)
+	if s.routeMetric(path, false, w, r) {
+		return
+	}
+
+	if strings.HasPrefix(path, apiV2Prefix+"/") && s.routeMetric(strings.TrimPrefix(path, apiV2Prefix), true, w, r) {
 		return
 	}
 
-	if strings.EqualFold(path, serviceMetricPath) {
-		s.handleServiceMetric(w, r)
+	if strings.EqualFold(path, prometheusMetricPath) {
+		s.handlePrometheusMetric(w, r)
 		return
 	}
 
@@ -83,10 +132,84 @@ func (s *MetricServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//if strings.EqualFold(path, "/health") {
-	//	s.handleHealth(w, r)
-	//}
+	if strings.EqualFold(path, selfMetricPath) {
+		s.handleSelfMetric(w, r)
+		return
+	}
+
+	if strings.EqualFold(path, healthPath) {
+		s.handleHealth(w, r)
+		return
+	}
+
+	if strings.EqualFold(path, readyPath) {
+		s.handleReady(w, r)
+		return
+	}
 
 	s.handleWelcome(path, w, r)
 	return
 }
+
+// routeMetric serves the metric APIs under the path, in the v2 format if v2 is true;
+// returns false if the path is not one of them.
+func (s *MetricServer) routeMetric(path string, v2 bool, w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case strings.EqualFold(path, appMetricPath):
+		s.handleAppMetric(v2, w, r)
+	case strings.EqualFold(path, serviceMetricPath):
+		s.handleServiceMetric(v2, w, r)
+	case strings.EqualFold(path, appMetricRangePath):
+		s.handleAppMetricRange(v2, w, r)
+	case strings.EqualFold(path, serviceMetricRangePath):
+		s.handleServiceMetricRange(v2, w, r)
+	case strings.HasPrefix(path, appMetricPath+"/"):
+		s.handleSinglePodMetric(strings.TrimPrefix(path, appMetricPath+"/"), v2, w, r)
+	case strings.HasPrefix(path, serviceMetricPath+"/"):
+		s.handleSingleServiceMetric(strings.TrimPrefix(path, serviceMetricPath+"/"), v2, w, r)
+	default:
+		return false
+	}
+	return true
+}
+
+// statusRecorder : records the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// routeOf returns the route of the path for the operational metrics, such as "/pod/metrics/{uid}";
+// the routes are bounded, and the unknown paths are "other".
+func routeOf(path string) string {
+	prefix := ""
+	if strings.HasPrefix(path, apiV2Prefix+"/") {
+		prefix, path = apiV2Prefix, strings.TrimPrefix(path, apiV2Prefix)
+	}
+
+	for _, route := range []string{appMetricPath, serviceMetricPath, appMetricRangePath, serviceMetricRangePath} {
+		if strings.EqualFold(path, route) {
+			return prefix + route
+		}
+	}
+	switch {
+	case strings.HasPrefix(path, appMetricPath+"/"):
+		return prefix + appMetricPath + "/{uid}"
+	case strings.HasPrefix(path, serviceMetricPath+"/"):
+		return prefix + serviceMetricPath + "/{namespace}/{name}"
+	case len(prefix) > 0:
+		return "other"
+	}
+
+	for _, route := range []string{prometheusMetricPath, selfMetricPath, healthPath, readyPath, fakeMetricPath, "/favicon.ico"} {
+		if strings.EqualFold(path, route) {
+			return route
+		}
+	}
+	return "other"
+}